@@ -0,0 +1,295 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Append-only audit log of security relevant events: who hashed,
+// retrieved or verified which key, who shut the server down, who was
+// turned away.  Each record is a line of JSON carrying the SHA-256 hash
+// of the record before it, so editing, dropping or reordering records
+// breaks the chain, as Verify tells.  Once the file grows past its
+// maximum size it is rotated to <path>.1, <path>.1 to <path>.2 and so on,
+// the chain running on across the files:
+//
+//    {"seq":7,"time":"2018-05-01T10:12:03.5Z","event":"verify","actor":"login",
+//     "client":"10.0.0.5","key":"5f1c...","outcome":"match",
+//     "request_id":"0a06b81dedc1e6f2","prev":"9d2e...","hash":"41c7..."}
+//
+// Truncating the newest records cannot be told from the file alone; keep
+// the head hash Verify reports somewhere else to check against later.
+//
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Genesis -- prev hash of the first record of a chain
+var Genesis = strings.Repeat("0", 64)
+
+// ErrNotRotated is returned by Record when the log file could not be
+// rotated.  The record was appended, to the file unrotated.
+var ErrNotRotated = errors.New("audit: log file not rotated")
+
+// events
+const (
+	EventHash         = "hash"          // POST /hash accepted or turned away
+	EventRetrieve     = "retrieve"      // GET /hash/{key}
+	EventVerify       = "verify"        // POST /verify
+	EventShutdown     = "shutdown"      // PUT /shutdown
+	EventAccessDenied = "access_denied" // request without credentials or role
+)
+
+// Record -- an audited event
+type Record struct {
+	Seq       int64  `json:"seq"`
+	Time      string `json:"time"` // RFC 3339, UTC
+	Event     string `json:"event"`
+	Actor     string `json:"actor,omitempty"`  // credential or client identity
+	Client    string `json:"client,omitempty"` // network address
+	Key       string `json:"key,omitempty"`
+	Outcome   string `json:"outcome"`
+	RequestID string `json:"request_id,omitempty"`
+	Prev      string `json:"prev"` // hash of the record before
+	Hash      string `json:"hash"` // hash of this record, Hash left empty
+}
+
+// digest -- the hash of r, that of its JSON with Hash empty
+func (r Record) digest() string {
+	r.Hash = ""
+	b, _ := json.Marshal(r)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log -- an audit log file, safe for concurrent use
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64    // rotate beyond this many bytes, 0 for never
+	keep    int      // rotated files kept
+	file    *os.File // nil if it could not be reopened
+	size    int64
+	seq     int64  // of the last record
+	last    string // hash of the last record
+}
+
+// Open -- open, creating if need be, the audit log at path, carrying on
+// the chain of its last record, or of the last file rotated if it has
+// none.  The file is rotated once past maxSize bytes, keeping keep old
+// files.
+func Open(path string, maxSize int64, keep int) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, keep: keep, last: Genesis}
+	for _, p := range []string{path, rotated(path, 1)} {
+		r, err := lastRecord(p)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			l.seq, l.last = r.Seq, r.Hash
+			break
+		}
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// rotated -- name of the n'th rotated file of path
+func rotated(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// lastRecord -- the last record of the file at path, nil if it has none
+// or does not exist
+func lastRecord(path string) (*Record, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+	line := data[bytes.LastIndexByte(data, '\n')+1:]
+	var r Record
+	if err := json.Unmarshal(line, &r); err != nil || r.digest() != r.Hash {
+		return nil, fmt.Errorf("audit: %s: last record damaged, check it with auditVerifyCmd", path)
+	}
+	return &r, nil
+}
+
+// open -- open the log file for appending
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, fi.Size()
+	return nil
+}
+
+// rotate -- move the log file to <path>.1, those before it along, and
+// start a new one.  If the files cannot be moved the log file is opened
+// again, to carry on unrotated.
+func (l *Log) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err == nil {
+		err = l.shift()
+	}
+	if oerr := l.open(); oerr != nil {
+		return errors.Join(err, oerr)
+	}
+	return err
+}
+
+// shift -- rename the rotated files to the next number up, dropping the
+// oldest, and the log file to <path>.1
+func (l *Log) shift() error {
+	for n := l.keep - 1; n >= 1; n-- {
+		err := os.Rename(rotated(l.path, n), rotated(l.path, n+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(l.path, rotated(l.path, 1))
+}
+
+// Record -- chain r on to the log and append it, synced to disk.  Seq,
+// Time, Prev and Hash are filled in.  If the log file is due to be rotated
+// and cannot be, r is appended all the same and ErrNotRotated returned.
+func (l *Log) Record(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	r.Seq = l.seq + 1
+	r.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	r.Prev = l.last
+	r.Hash = r.digest()
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	var rotateErr error
+	if l.maxSize > 0 && l.keep > 0 && l.size > 0 &&
+		l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			rotateErr = fmt.Errorf("%w: %s: %v", ErrNotRotated, l.path, err)
+		}
+		if l.file == nil {
+			return rotateErr
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	l.seq, l.last = r.Seq, r.Hash
+	return rotateErr
+}
+
+// Close -- close the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Chain -- what Verify found of a chain
+type Chain struct {
+	Records  int    // records checked
+	First    int64  // seq of the first
+	Last     int64  // seq of the last
+	Head     string // hash of the last
+	Anchored bool   // whether the first is the start of the chain
+}
+
+// Verify -- check the chain of records of the audit log files at paths,
+// oldest first, stopping at the first broken link
+func Verify(paths ...string) (Chain, error) {
+	var c Chain
+	for _, path := range paths {
+		if err := c.verifyFile(path); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// verifyFile -- chain the records of the file at path on to c
+func (c *Chain) verifyFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for lineno := 1; sc.Scan(); lineno++ {
+		if err := c.check(sc.Bytes()); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineno, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// check -- chain the record of line on to c
+func (c *Chain) check(line []byte) error {
+	var r Record
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&r); err != nil {
+		return fmt.Errorf("malformed record: %v", err)
+	}
+	if r.digest() != r.Hash {
+		return fmt.Errorf("record %d altered, hash does not match", r.Seq)
+	}
+	if c.Records == 0 {
+		c.First = r.Seq
+		c.Anchored = r.Seq == 1 && r.Prev == Genesis
+	} else if r.Seq != c.Last+1 || r.Prev != c.Head {
+		return fmt.Errorf("chain broken between records %d and %d", c.Last,
+			r.Seq)
+	}
+	c.Records++
+	c.Last, c.Head = r.Seq, r.Hash
+	return nil
+}
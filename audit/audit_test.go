@@ -0,0 +1,252 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of the audit log: that Verify passes what Record wrote and finds
+// every edit, that the chain runs on across rotated files and reopening,
+// and that a log which cannot be rotated keeps recording.
+//
+
+package audit_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stevewahl/GoTest/audit"
+)
+
+// open -- an audit log at path, closed when the test ends
+func open(t *testing.T, path string, maxSize int64, keep int) *audit.Log {
+	t.Helper()
+	l, err := audit.Open(path, maxSize, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// record -- record n verify events in l
+func record(t *testing.T, l *audit.Log, n int) {
+	t.Helper()
+	for range n {
+		err := l.Record(audit.Record{Event: audit.EventVerify, Actor: "login",
+			Client: "10.0.0.5", Key: "9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13",
+			Outcome: "match"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readRecords -- the records of the audit log file at path
+func readRecords(t *testing.T, path string) []audit.Record {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []audit.Record
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r audit.Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%s: %q: %v", path, line, err)
+		}
+		recs = append(recs, r)
+	}
+	return recs
+}
+
+// writeRecords -- write recs as the audit log file at path
+func writeRecords(t *testing.T, path string, recs []audit.Record) {
+	t.Helper()
+	var b strings.Builder
+	for _, r := range recs {
+		line, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rehash -- r with its Hash made good again, as a forger would
+func rehash(r audit.Record) audit.Record {
+	r.Hash = ""
+	b, _ := json.Marshal(r)
+	sum := sha256.Sum256(b)
+	r.Hash = hex.EncodeToString(sum[:])
+	return r
+}
+
+func TestRecordVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := open(t, path, 0, 0)
+	record(t, l, 3)
+	chain, err := audit.Verify(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := readRecords(t, path)
+	if chain.Records != 3 || chain.First != 1 || chain.Last != 3 ||
+		!chain.Anchored || chain.Head != recs[2].Hash {
+		t.Errorf("Verify = %+v", chain)
+	}
+	if recs[0].Prev != audit.Genesis || recs[1].Prev != recs[0].Hash ||
+		recs[2].Prev != recs[1].Hash {
+		t.Errorf("records not chained: %+v", recs)
+	}
+	for _, r := range recs {
+		if r.Time == "" || r.Outcome != "match" {
+			t.Errorf("record %+v", r)
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := open(t, path, 0, 0)
+	record(t, l, 3)
+	recs := readRecords(t, path)
+	for _, tc := range []struct {
+		what string
+		edit func([]audit.Record) []audit.Record
+		want string
+	}{
+		{"altered", func(rs []audit.Record) []audit.Record {
+			rs[1].Outcome = "no_match"
+			return rs
+		}, "record 2 altered"},
+		{"altered and rehashed", func(rs []audit.Record) []audit.Record {
+			rs[1].Outcome = "no_match"
+			rs[1] = rehash(rs[1])
+			return rs
+		}, "chain broken between records 2 and 3"},
+		{"broken prev", func(rs []audit.Record) []audit.Record {
+			rs[1].Prev = audit.Genesis
+			rs[1] = rehash(rs[1])
+			return rs
+		}, "chain broken between records 1 and 2"},
+		{"dropped", func(rs []audit.Record) []audit.Record {
+			return append(rs[:1], rs[2:]...)
+		}, "chain broken between records 1 and 3"},
+		{"reordered", func(rs []audit.Record) []audit.Record {
+			rs[1], rs[2] = rs[2], rs[1]
+			return rs
+		}, "chain broken between records 1 and 3"},
+	} {
+		edited := filepath.Join(t.TempDir(), "audit.log")
+		writeRecords(t, edited, tc.edit(append([]audit.Record{}, recs...)))
+		_, err := audit.Verify(edited)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Verify error %v, want %q", tc.what, err, tc.want)
+		}
+	}
+	if err := os.WriteFile(path, []byte("{\"seq\": 1, \"extra\": 1}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := audit.Verify(path); err == nil ||
+		!strings.Contains(err.Error(), "malformed record") {
+		t.Errorf("malformed: Verify error %v", err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// room for two records a file
+	l := open(t, path, 800, 2)
+	record(t, l, 7)
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("more than 2 rotated files kept: %v", err)
+	}
+	chain, err := audit.Verify(path+".2", path+".1", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.Records != 5 || chain.First != 3 || chain.Last != 7 ||
+		chain.Anchored {
+		t.Errorf("Verify = %+v", chain)
+	}
+	// the chain runs on from one file to the next
+	if readRecords(t, path)[0].Prev != readRecords(t, path+".1")[1].Hash {
+		t.Error("chain not carried on into the new file")
+	}
+}
+
+func TestRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := open(t, path, 800, 1)
+	record(t, l, 2)
+	// a directory in the way of renaming the log file to <path>.1
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	rec := audit.Record{Event: audit.EventShutdown, Outcome: "accepted"}
+	for range 2 {
+		if err := l.Record(rec); !errors.Is(err, audit.ErrNotRotated) {
+			t.Fatalf("Record = %v, want ErrNotRotated", err)
+		}
+	}
+	chain, err := audit.Verify(path)
+	if err != nil || chain.Records != 4 {
+		t.Fatalf("Verify = %+v, %v, want the 4 records unrotated", chain, err)
+	}
+	// once out of the way, rotation carries on
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Record(rec); err != nil {
+		t.Fatal(err)
+	}
+	chain, err = audit.Verify(path+".1", path)
+	if err != nil || chain.Records != 5 || !chain.Anchored {
+		t.Errorf("Verify = %+v, %v", chain, err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	l, err := audit.Open(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, l, 2)
+	l.Close()
+	record(t, open(t, path, 0, 0), 1)
+	recs := readRecords(t, path)
+	if len(recs) != 3 || recs[2].Seq != 3 || recs[2].Prev != recs[1].Hash {
+		t.Fatalf("records after reopening: %+v", recs)
+	}
+
+	// with the log file just rotated, from the last rotated file
+	moved := filepath.Join(dir, "moved.log")
+	if err := os.Rename(path, moved+".1"); err != nil {
+		t.Fatal(err)
+	}
+	record(t, open(t, moved, 0, 0), 1)
+	chain, err := audit.Verify(moved+".1", moved)
+	if err != nil || chain.Records != 4 || chain.Last != 4 {
+		t.Errorf("Verify = %+v, %v", chain, err)
+	}
+
+	// not over a damaged last record
+	recs[2].Outcome = "no_match"
+	writeRecords(t, path, recs)
+	if _, err := audit.Open(path, 0, 0); err == nil {
+		t.Error("Open of a log with a damaged last record")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Command that checks the hash chain of an audit log written by
+// httpHashPWsvr_no6 -auditlog, see the audit package.  Give the rotated
+// files too, oldest first, to check the chain across them.  Exits 0 and
+// prints the head hash if the chain is intact, else exits 1 naming the
+// first broken record.  Example:
+// $ ./auditVerifyCmd /var/log/hashpw-audit.log.2 /var/log/hashpw-audit.log.1 \
+//     /var/log/hashpw-audit.log
+// ok: 1042 records, seq 1 to 1042, head 41c7...
+//
+
+package main
+
+import (
+	"fmt"
+	"github.com/stevewahl/GoTest/audit"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Printf("Usage:\n   %s <audit-file>...\n", os.Args[0])
+		fmt.Printf("    <audit-file>  ::  audit log files, oldest first\n\n")
+		os.Exit(1)
+	}
+	chain, err := audit.Verify(os.Args[1:]...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "audit chain broken:", err)
+		os.Exit(1)
+	}
+	if chain.Records == 0 {
+		fmt.Println("ok: no records")
+		return
+	}
+	fmt.Printf("ok: %d records, seq %d to %d, head %s\n", chain.Records,
+		chain.First, chain.Last, chain.Head)
+	if !chain.Anchored {
+		fmt.Printf("note: chain starts at record %d, the files before it "+
+			"not given\n", chain.First)
+	}
+}
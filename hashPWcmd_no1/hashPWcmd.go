@@ -5,10 +5,15 @@
 // http://steeltemple.com/steve/LICENSE
 //
 // Command that takes a cleartext password string and returns to
-// stdout a Base64 encoded string of the SHA512 hashing of the 
-// password string.  Example:
+// stdout the salted Argon2id hash of the password string, in PHC
+// string format.  Each run uses a new random salt.  Example:
 // $ ./hashPWcmd_no1 "angryMonkey"
-// ZEHhWB65gUlzdVwtDQArEyx-KVLzp_aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A-gf7Q==
+// $argon2id$v=19$m=65536,t=1,p=4$dZtifBwlMmaUTiWfmEmGxw$xyAC98pq3HtdgEdj0LO91ekLUveC62tNAcpCVepJkbY
+//
+// An optional second argument names the hashing algorithm to use
+// (sha512, bcrypt, scrypt, argon2id or pbkdf2-sha512).  Example:
+// $ ./hashPWcmd_no1 "angryMonkey" bcrypt
+// $2a$10$...
 //
 
 package main
@@ -16,14 +21,33 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	hashpass "github.com/stevewahl/GoTest/pwhashutil"
 )
 
 func main() {
-	if len(os.Args) != 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
-		fmt.Printf("Usage:\n   %s <password-string>\n", os.Args[0])
-		fmt.Printf("    <password-string> ::  quoted clear-text password\n\n")
+	if len(os.Args) < 2 || len(os.Args) > 3 ||
+		os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Printf("Usage:\n   %s <password-string> [algorithm]\n", os.Args[0])
+		fmt.Printf("    <password-string> ::  quoted clear-text password\n")
+		fmt.Printf("    [algorithm]       ::  one of %s (default %s)\n\n",
+			strings.Join(hashpass.Algorithms(), ", "),
+			hashpass.DefaultAlgorithm)
+		os.Exit(1)
+	}
+	algo := hashpass.DefaultAlgorithm
+	if len(os.Args) == 3 {
+		algo = os.Args[2]
+	}
+	hasher, err := hashpass.NewHasher(algo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	pwhash, err := hasher.Hash(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	fmt.Printf("%s\n", hashpass.HashifyPW(os.Args[1]))
+	fmt.Printf("%s\n", pwhash)
 }
@@ -0,0 +1,589 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Command that loads a password hashing server, httpHashPWsvr_no6 or one
+// it starts in process, and reports the throughput, latency percentiles
+// and errors of each kind of request.  Clients make hash, get and verify
+// requests in the proportions of -mix, get and verify using the keys
+// handed out earlier in the run.  Until a password is hashed its get is
+// answered 202 and its verify 404, neither counted as errors.  With -wait
+// each hash request also waits for its password to be hashed, timed as
+// "hashed".  Example:
+// $ ./hashbench -url http://localhost:8088 -c 16 -rate 200 -duration 30s \
+//     -mix hash=7,get=1,verify=2
+// hashbench: 30.0s, 16 clients, 5998 requests, 199.9 req/s, 0 errors
+//     op  count  errors  p50 ms  p90 ms  p99 ms  max ms
+//    get    598       0    0.41    0.93    2.10    4.77
+//   hash   4196       0    0.52    1.11    2.64    9.30
+// verify   1204       0   61.02   88.35  120.47  151.96
+//
+// To compare algorithms and costs, run the server in process and append
+// the results as JSON lines:
+// $ ./hashbench -inprocess -algorithm bcrypt -params cost=12 -wait -json \
+//     -label bcrypt12 >> bench.jsonl
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/stevewahl/GoTest/hashserver"
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// kinds of request, and the hash waits of -wait
+const (
+	opHash   = "hash"
+	opGet    = "get"
+	opVerify = "verify"
+	opHashed = "hashed"
+)
+
+// settings -- how the server was loaded, reported with the results
+type settings struct {
+	URL         string           `json:"url"`
+	InProcess   bool             `json:"in_process"`
+	Algorithm   string           `json:"algorithm,omitempty"`
+	Params      svrconfig.Params `json:"params,omitempty"`
+	Workers     int              `json:"workers,omitempty"`
+	MaxHashing  int              `json:"max_hashing,omitempty"`
+	Concurrency int              `json:"concurrency"`
+	Rate        float64          `json:"rate"`
+	Duration    string           `json:"duration"`
+	Mix         svrconfig.Params `json:"mix"`
+	Passwords   string           `json:"passwords"`
+	Wait        bool             `json:"wait"`
+	Label       string           `json:"label,omitempty"`
+}
+
+// latency -- percentiles of the latencies of a kind of request, in
+// milliseconds
+type latency struct {
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+// opStats -- results of a kind of request
+type opStats struct {
+	Count   int            `json:"count"`
+	Errors  map[string]int `json:"errors,omitempty"` // by status code, or "network"
+	Latency latency        `json:"latency_ms"`
+	took    []time.Duration
+}
+
+// report -- results of a run
+type report struct {
+	Settings   settings            `json:"settings"`
+	Start      string              `json:"start"`
+	Elapsed    float64             `json:"elapsed_s"`
+	Requests   int                 `json:"requests"`
+	Errors     int                 `json:"errors"`
+	Throughput float64             `json:"throughput"` // requests a second
+	Ops        map[string]*opStats `json:"ops"`
+}
+
+// hashed -- a password hashed by the server under key, for get and verify
+type hashed struct {
+	key string
+	pw  string
+}
+
+// bench -- a run in progress
+type bench struct {
+	url       string
+	apiKey    string
+	client    *http.Client
+	mix       []string // op names, each repeated by its weight
+	passwords func(r *rand.Rand) string
+	wait      bool
+	tokens    <-chan struct{} // paced by -rate, nil for flat out
+
+	mu    sync.Mutex
+	ops   map[string]*opStats
+	known []hashed // ring of keys handed out
+	next  int
+}
+
+// maxKnown -- keys kept for get and verify
+const maxKnown = 1000
+
+// usage -- print the command line help
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage:  %s [options]\n",
+		os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(),
+		"  load a password hashing server and report its throughput and latencies\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var set settings
+	var duration, waitFor time.Duration
+	var queue int
+	var apiKey string
+	var asJSON bool
+	set.Params = svrconfig.Params{}
+	set.Mix = svrconfig.Params{opHash: 1}
+	flag.StringVar(&set.URL, "url", "http://localhost:8088",
+		"URL of the server, ignored with -inprocess")
+	flag.BoolVar(&set.InProcess, "inprocess", false,
+		"start a server in this process and load it rather than -url")
+	flag.StringVar(&set.Algorithm, "algorithm", passhash.DefaultAlgorithm,
+		"password hashing algorithm of the -inprocess server, one of "+
+			strings.Join(passhash.Algorithms(), ", "))
+	flag.Var(set.Params, "params",
+		"algorithm cost parameters of the -inprocess server as name=value,...")
+	flag.IntVar(&set.Workers, "workers", runtime.NumCPU(),
+		"hash workers of the -inprocess server")
+	flag.IntVar(&queue, "queue", 1000,
+		"hash job queue length of the -inprocess server")
+	flag.IntVar(&set.MaxHashing, "maxhashing", runtime.NumCPU(),
+		"most passwords the -inprocess server hashes or verifies at once")
+	flag.IntVar(&set.Concurrency, "c", 4, "concurrent clients")
+	flag.Float64Var(&set.Rate, "rate", 0,
+		"requests a second across all clients, 0 for as fast as answered")
+	flag.DurationVar(&duration, "duration", 10*time.Second,
+		"time to load the server for")
+	flag.Var(set.Mix, "mix",
+		"relative weights of the requests made, as hash=n,get=n,verify=n")
+	flag.StringVar(&set.Passwords, "passwords", "random:8-16",
+		"passwords sent: random:<min>-<max> letters and digits, "+
+			"fixed:<password>, or file:<path> one a line, picked at random")
+	flag.BoolVar(&set.Wait, "wait", false,
+		"after each hash request wait for the password to be hashed")
+	flag.DurationVar(&waitFor, "waittimeout", time.Minute,
+		"longest time -wait waits for a password to be hashed")
+	flag.StringVar(&apiKey, "apikey", "",
+		"API key sent as X-API-Key, for a server with -authfile")
+	flag.StringVar(&set.Label, "label", "", "name of the run, kept in the results")
+	flag.BoolVar(&asJSON, "json", false, "report the results as a line of JSON")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() > 0 || set.Concurrency < 1 || set.Rate < 0 || duration <= 0 {
+		usage()
+		os.Exit(1)
+	}
+	set.Duration = duration.String()
+
+	b := &bench{url: strings.TrimRight(set.URL, "/"), apiKey: apiKey,
+		wait: set.Wait, ops: map[string]*opStats{}}
+	var err error
+	if b.mix, err = opMix(set.Mix); err == nil {
+		b.passwords, err = passwordSource(set.Passwords)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "hashbench:", err)
+		usage()
+		os.Exit(1)
+	}
+	if set.InProcess {
+		stop, addr, err := startServer(set, queue)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "hashbench: unable to start the server:", err)
+			os.Exit(1)
+		}
+		defer stop()
+		b.url = "http://" + addr
+		set.URL = b.url
+	} else {
+		set.Algorithm, set.Params, set.Workers, set.MaxHashing = "", nil, 0, 0
+	}
+	b.client = &http.Client{Timeout: time.Minute,
+		Transport: &http.Transport{MaxIdleConnsPerHost: set.Concurrency}}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	if set.Rate > 0 {
+		b.tokens = pace(ctx, set.Rate)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < set.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.run(ctx, waitFor)
+		}()
+	}
+	wg.Wait()
+	rep := b.report(set, start, time.Since(start))
+	if asJSON {
+		line, _ := json.Marshal(rep)
+		fmt.Printf("%s\n", line)
+	} else {
+		rep.write(os.Stdout)
+	}
+}
+
+// opMix -- the op names of weights, each repeated by its weight
+func opMix(weights svrconfig.Params) ([]string, error) {
+	var mix []string
+	for op, n := range weights {
+		if op != opHash && op != opGet && op != opVerify || n < 0 {
+			return nil, fmt.Errorf("bad -mix %s=%d, expecting hash, get or verify "+
+				"with a weight of 0 or more", op, n)
+		}
+		for ; n > 0; n-- {
+			mix = append(mix, op)
+		}
+	}
+	if len(mix) == 0 {
+		return nil, errors.New("-mix gives no requests to make")
+	}
+	return mix, nil
+}
+
+// passwordSource -- a generator of the passwords of spec, the syntax of
+// -passwords
+func passwordSource(spec string) (func(r *rand.Rand) string, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "random":
+		lo, hi, ok := strings.Cut(arg, "-")
+		if !ok {
+			hi = lo
+		}
+		min, err1 := strconv.Atoi(lo)
+		max, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || min < 1 || max < min {
+			return nil, fmt.Errorf("bad -passwords %q, expecting random:<min>-<max>", spec)
+		}
+		const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		return func(r *rand.Rand) string {
+			pw := make([]byte, min+r.IntN(max-min+1))
+			for i := range pw {
+				pw[i] = letters[r.IntN(len(letters))]
+			}
+			return string(pw)
+		}, nil
+	case "fixed":
+		if arg == "" {
+			return nil, errors.New("-passwords fixed: needs a password")
+		}
+		return func(*rand.Rand) string { return arg }, nil
+	case "file":
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		var pws []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimRight(line, "\r"); line != "" {
+				pws = append(pws, line)
+			}
+		}
+		if len(pws) == 0 {
+			return nil, fmt.Errorf("no passwords in %s", arg)
+		}
+		return func(r *rand.Rand) string { return pws[r.IntN(len(pws))] }, nil
+	}
+	return nil, fmt.Errorf("bad -passwords %q, expecting random:, fixed: or file:", spec)
+}
+
+// startServer -- serve a hashserver of set on a local port, returning a
+// function stopping it and its address
+func startServer(set settings, queue int) (func(), string, error) {
+	hasher, err := passhash.NewHasherParams(set.Algorithm, set.Params)
+	if err != nil {
+		return nil, "", err
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelWarn, ReplaceAttr: svrconfig.RedactAttr}))
+	hs, err := hashserver.New(hashserver.WithHasher(hasher),
+		hashserver.WithWorkers(set.Workers, queue),
+		hashserver.WithLimits(0, 1, set.MaxHashing),
+		hashserver.WithLogger(logger))
+	if err != nil {
+		return nil, "", err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		hs.Shutdown(context.Background())
+		return nil, "", err
+	}
+	srv := &http.Server{Handler: hs}
+	go srv.Serve(ln)
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		hs.Shutdown(ctx)
+	}
+	return stop, ln.Addr().String(), nil
+}
+
+// pace -- channel yielding rate tokens a second until ctx is done
+func pace(ctx context.Context, rate float64) <-chan struct{} {
+	tokens := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			select {
+			case tokens <- struct{}{}:
+			default: // every client busy, the server is slower than rate
+			}
+		}
+	}()
+	return tokens
+}
+
+// run -- make requests as one client until ctx is done
+func (b *bench) run(ctx context.Context, waitFor time.Duration) {
+	r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	for {
+		if b.tokens != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.tokens:
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		op := b.mix[r.IntN(len(b.mix))]
+		target, ok := b.knownKey(r)
+		if !ok {
+			op = opHash // nothing hashed yet to get or verify
+		}
+		switch op {
+		case opHash:
+			pw := b.passwords(r)
+			key, ok := b.do(ctx, opHash, "POST", "/hash",
+				url.Values{"password": {pw}}, http.StatusOK)
+			if !ok {
+				continue
+			}
+			if b.wait {
+				b.waitHashed(key, time.Now(), waitFor)
+			}
+			b.remember(hashed{key: key, pw: pw})
+		case opGet:
+			b.do(ctx, opGet, "GET", "/hash/"+target.key, nil, http.StatusOK,
+				http.StatusAccepted)
+		case opVerify:
+			b.do(ctx, opVerify, "POST", "/verify", url.Values{"key": {target.key},
+				"password": {target.pw}}, http.StatusOK, http.StatusNotFound)
+		}
+	}
+}
+
+// do -- make a request, timing it as op, and return the body if it was
+// answered with one of the statuses wanted
+func (b *bench) do(ctx context.Context, op, method, path string,
+	form url.Values, want ...int) (string, bool) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.url+path, body)
+	if err != nil {
+		return "", false
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if b.apiKey != "" {
+		req.Header.Set("X-API-Key", b.apiKey)
+	}
+	start := time.Now()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			b.record(op, time.Since(start), "network")
+		}
+		return "", false
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	took := time.Since(start)
+	if err != nil {
+		b.record(op, took, "network")
+		return "", false
+	}
+	if !slices.Contains(want, resp.StatusCode) {
+		b.record(op, took, strconv.Itoa(resp.StatusCode))
+		return "", false
+	}
+	b.record(op, took, "")
+	return strings.TrimSpace(string(data)), true
+}
+
+// waitHashed -- poll for the hash of key, POSTed at posted, timing how
+// long it took to be hashed
+func (b *bench) waitHashed(key string, posted time.Time, waitFor time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), waitFor)
+	defer cancel()
+	for {
+		req, _ := http.NewRequestWithContext(ctx, "GET", b.url+"/hash/"+key, nil)
+		if b.apiKey != "" {
+			req.Header.Set("X-API-Key", b.apiKey)
+		}
+		resp, err := b.client.Do(req)
+		if err != nil {
+			b.record(opHashed, time.Since(posted), "network")
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			errCode := ""
+			if resp.StatusCode != http.StatusOK {
+				errCode = strconv.Itoa(resp.StatusCode)
+			}
+			b.record(opHashed, time.Since(posted), errCode)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			b.record(opHashed, time.Since(posted), "timeout")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// record -- count a request of op taking took, failed with errCode
+// unless it is ""
+func (b *bench) record(op string, took time.Duration, errCode string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.ops[op]
+	if st == nil {
+		st = &opStats{Errors: map[string]int{}}
+		b.ops[op] = st
+	}
+	st.Count++
+	if errCode != "" {
+		st.Errors[errCode]++
+		return
+	}
+	st.took = append(st.took, took)
+}
+
+// remember -- keep h for later get and verify requests
+func (b *bench) remember(h hashed) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.known) < maxKnown {
+		b.known = append(b.known, h)
+		return
+	}
+	b.known[b.next] = h
+	b.next = (b.next + 1) % maxKnown
+}
+
+// knownKey -- a key handed out earlier, at random
+func (b *bench) knownKey(r *rand.Rand) (hashed, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.known) == 0 {
+		return hashed{}, false
+	}
+	return b.known[r.IntN(len(b.known))], true
+}
+
+// report -- the results of the run, begun at start and lasting elapsed
+func (b *bench) report(set settings, start time.Time, elapsed time.Duration) report {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rep := report{Settings: set, Start: start.UTC().Format(time.RFC3339),
+		Elapsed: elapsed.Seconds(), Ops: b.ops}
+	for op, st := range b.ops {
+		st.Latency = percentiles(st.took)
+		if op == opHashed {
+			continue // not requests of their own
+		}
+		rep.Requests += st.Count
+		for _, n := range st.Errors {
+			rep.Errors += n
+		}
+	}
+	rep.Throughput = float64(rep.Requests) / elapsed.Seconds()
+	return rep
+}
+
+// percentiles -- the latency percentiles of took
+func percentiles(took []time.Duration) latency {
+	if len(took) == 0 {
+		return latency{}
+	}
+	slices.Sort(took)
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	at := func(p float64) float64 {
+		return ms(took[int(p*float64(len(took)-1))])
+	}
+	var total time.Duration
+	for _, d := range took {
+		total += d
+	}
+	return latency{P50: at(0.50), P90: at(0.90), P99: at(0.99),
+		Max: ms(took[len(took)-1]), Mean: ms(total / time.Duration(len(took)))}
+}
+
+// write -- print rep as a table
+func (rep report) write(w io.Writer) {
+	label := ""
+	if rep.Settings.Label != "" {
+		label = " " + rep.Settings.Label
+	}
+	fmt.Fprintf(w, "hashbench%s: %.1fs, %d clients, %d requests, %.1f req/s, %d errors\n",
+		label, rep.Elapsed, rep.Settings.Concurrency, rep.Requests,
+		rep.Throughput, rep.Errors)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tcount\terrors\tp50 ms\tp90 ms\tp99 ms\tmax ms\t")
+	ops := make([]string, 0, len(rep.Ops))
+	for op := range rep.Ops {
+		ops = append(ops, op)
+	}
+	slices.Sort(ops)
+	var failures []string
+	for _, op := range ops {
+		st := rep.Ops[op]
+		errs := 0
+		for code, n := range st.Errors {
+			errs += n
+			failures = append(failures, fmt.Sprintf("%s %s x%d", op, code, n))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t\n", op, st.Count,
+			errs, st.Latency.P50, st.Latency.P90, st.Latency.P99, st.Latency.Max)
+	}
+	tw.Flush()
+	if len(failures) > 0 {
+		slices.Sort(failures)
+		fmt.Fprintf(w, "errors: %s\n", strings.Join(failures, ", "))
+	}
+}
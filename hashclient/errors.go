@@ -0,0 +1,132 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Errors of the server.  Every answer with a status other than those a
+// request expects is an *Error, carrying the status, the server's error
+// code and message, and any Retry-After.  errors.Is matches it against
+// the Err values by code:
+//
+//    _, err := c.Get(ctx, key)
+//    var e *hashclient.Error
+//    switch {
+//    case errors.Is(err, hashclient.ErrNotFound):   // no such key
+//    case errors.Is(err, hashclient.ErrJobExpired): // never hashed
+//    case errors.As(err, &e):                       // e.StatusCode, e.Message
+//    }
+//
+// Servers answering in plain text, as the earlier httpHashPWsvr_no2 to
+// no5 do, get the code of their status.
+//
+
+package hashclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error -- an error answer of the server
+type Error struct {
+	StatusCode int           // http status
+	Code       string        // e.g. "not_found", see the Err values
+	Message    string        // as the server put it
+	RetryAfter time.Duration // from the Retry-After header, 0 if none
+}
+
+// errors of the server, to match with errors.Is
+var (
+	ErrBadRequest       = &Error{Code: "bad_request"}
+	ErrInvalidKey       = &Error{Code: "invalid_key"}
+	ErrUnknownAlgorithm = &Error{Code: "unknown_algorithm"}
+	ErrBadParams        = &Error{Code: "bad_params"}
+	ErrNotAllowed       = &Error{Code: "not_allowed"}
+	ErrBadHash          = &Error{Code: "bad_hash_format"}
+	ErrUnauthorized     = &Error{Code: "unauthorized"}
+	ErrForbidden        = &Error{Code: "forbidden"}
+	ErrNotFound         = &Error{Code: "not_found"}
+	ErrBadMethod        = &Error{Code: "method_not_allowed"}
+	ErrJobExpired       = &Error{Code: "job_expired"}
+	ErrTooLarge         = &Error{Code: "request_too_large"}
+	ErrBadMediaType     = &Error{Code: "unsupported_media_type"}
+	ErrShuttingDown     = &Error{Code: "shutting_down"}
+	ErrRateLimited      = &Error{Code: "rate_limited"}
+	ErrHashFailed       = &Error{Code: "hash_failed"}
+	ErrInternal         = &Error{Code: "internal_error"}
+	ErrBusy             = &Error{Code: "busy"}
+)
+
+// statusCodes -- code of an answer without one
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusGone:                  "job_expired",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusExpectationFailed:     "shutting_down",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusServiceUnavailable:    "busy",
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		return "hashclient: " + e.Code
+	}
+	return fmt.Sprintf("hashclient: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is -- whether target is an Error of the same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// temporary -- whether the request may succeed if made again later
+func (e *Error) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusServiceUnavailable
+}
+
+// newError -- the Error of an answer of status, with body and header
+func newError(status int, body []byte, header http.Header) *Error {
+	e := &Error{StatusCode: status,
+		RetryAfter: retryAfter(header.Get("Retry-After"))}
+	var eb struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if json.Unmarshal(body, &eb) == nil && eb.Code != "" {
+		e.Code, e.Message = eb.Code, eb.Error
+		return e
+	}
+	e.Code, e.Message = statusCodes[status], strings.TrimSpace(string(body))
+	if e.Code == "" {
+		e.Code = "http_" + strconv.Itoa(status)
+	}
+	return e
+}
+
+// retryAfter -- the wait of a Retry-After header, in seconds or an http
+// date, 0 if none
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
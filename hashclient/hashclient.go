@@ -0,0 +1,366 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Package hashclient is a Go client of the http password hashing service
+// of httpHashPWsvr_no6 and the hashserver package.  It asks for JSON
+// answers, so callers get typed results rather than parsing the
+// newline-terminated bodies, and failures as *Error (see errors.go):
+//
+//    c, err := hashclient.New("http://localhost:8088",
+//        hashclient.WithAPIKey(key))
+//    if err != nil { ... }
+//    r, err := c.Hash(ctx, "angryMonkey")
+//    r, err = c.Wait(ctx, r.Key)       // poll until hashed
+//    v, err := c.Verify(ctx, r.Key, "angryMonkey")
+//    if errors.Is(err, hashclient.ErrNotFound) { ... }
+//
+// Requests turned away with 429 or 503 are retried, after the Retry-After
+// the server gave or else a growing backoff, unless that would outlast
+// the context.
+//
+
+package hashclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// job states, of Result and JobStatus
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+	StatusExpired = "expired"
+)
+
+// Result -- answer of POST /hash and GET /hash/{key}
+type Result struct {
+	Key       string `json:"key"`
+	Status    string `json:"status"`
+	Hash      string `json:"hash,omitempty"` // once Status is done
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// JobStatus -- progress of the hash job of a key, GET /hash/{key}/status
+type JobStatus struct {
+	Key      string     `json:"key"`
+	Status   string     `json:"status"`
+	Queued   *time.Time `json:"queued_at,omitempty"`
+	Started  *time.Time `json:"started_at,omitempty"`
+	Finished *time.Time `json:"finished_at,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// VerifyResult -- answer of POST /verify
+type VerifyResult struct {
+	Match    bool   `json:"match"`
+	Key      string `json:"key,omitempty"`
+	Rehashed bool   `json:"rehashed,omitempty"` // stored hash replaced
+}
+
+// Stats -- answer of GET /stats
+type Stats struct {
+	Total    int                      `json:"total"`            // number of hash requests
+	Average  int                      `json:"average"`          // mean microseconds per hash request
+	Stored   int                      `json:"stored,omitempty"` // of Total, those stored before start
+	Window   string                   `json:"window,omitempty"`
+	InFlight int                      `json:"in_flight"`   // requests being served
+	Queued   int                      `json:"queue_depth"` // passwords waiting for a worker
+	QueueCap int                      `json:"queue_capacity"`
+	Requests map[string]EndpointStats `json:"requests"` // by endpoint
+	Errors   map[int]int              `json:"errors"`   // by status code
+	Latency  LatencyStats             `json:"latency"`
+	Limited  int                      `json:"rate_limited"` // requests turned away
+	Hashing  int                      `json:"hashing"`      // passwords being hashed or verified
+	HashCap  int                      `json:"hashing_capacity"`
+}
+
+// EndpointStats -- requests to one endpoint, in Stats
+type EndpointStats struct {
+	Count  int         `json:"count"`
+	Errors map[int]int `json:"errors,omitempty"` // by status code
+}
+
+// LatencyStats -- microseconds from POST /hash to the password being
+// stored, in Stats
+type LatencyStats struct {
+	Count int `json:"count"`
+	P50   int `json:"p50"`
+	P90   int `json:"p90"`
+	P99   int `json:"p99"`
+	Max   int `json:"max"`
+}
+
+// polling of Wait, and backoff of retries without a Retry-After
+const (
+	minPoll    = 50 * time.Millisecond
+	maxPoll    = 2 * time.Second
+	minBackoff = 250 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// maxBody -- longest answer read
+const maxBody = 1 << 20
+
+// Client -- a client of one server, safe for concurrent use
+type Client struct {
+	base    *url.URL
+	hc      *http.Client
+	apiKey  string
+	token   string
+	retries int
+}
+
+// Option -- a setting of a Client, given to New
+type Option func(*Client) error
+
+// WithHTTPClient -- make requests with hc, e.g. for TLS client
+// certificates or a Unix socket transport
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) error {
+		c.hc = hc
+		return nil
+	}
+}
+
+// WithAPIKey -- send key as X-API-Key, for a server with -authfile
+func WithAPIKey(key string) Option {
+	return func(c *Client) error {
+		c.apiKey = key
+		return nil
+	}
+}
+
+// WithBearerToken -- send token as "Authorization: Bearer <token>"
+func WithBearerToken(token string) Option {
+	return func(c *Client) error {
+		c.token = token
+		return nil
+	}
+}
+
+// WithRetries -- retry requests turned away with 429 or 503 up to n
+// times, 0 for never
+func WithRetries(n int) Option {
+	return func(c *Client) error {
+		if n < 0 {
+			return errors.New("hashclient: retries must be 0 or more")
+		}
+		c.retries = n
+		return nil
+	}
+}
+
+// New -- a Client of the server at baseURL, e.g. "http://localhost:8088"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("hashclient: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("hashclient: %q is not an http or https URL", baseURL)
+	}
+	c := &Client{base: base, hc: &http.Client{Timeout: 30 * time.Second},
+		retries: 3}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Hash -- hand password to the server to hash with its algorithm.  The
+// Result has the key to get the hash by, once hashed.
+func (c *Client) Hash(ctx context.Context, password string) (Result, error) {
+	var r Result
+	form := url.Values{"password": {password}}
+	err := c.do(ctx, "POST", "/hash", []byte(form.Encode()),
+		"application/x-www-form-urlencoded", &r, http.StatusOK)
+	return r, err
+}
+
+// HashWith -- Hash, with the algorithm and cost parameters given rather
+// than the server's.  An empty algorithm is the server's.
+func (c *Client) HashWith(ctx context.Context, password, algorithm string,
+	params map[string]int) (Result, error) {
+	body, err := json.Marshal(struct {
+		Password  string         `json:"password"`
+		Algorithm string         `json:"algorithm,omitempty"`
+		Params    map[string]int `json:"params,omitempty"`
+	}{password, algorithm, params})
+	if err != nil {
+		return Result{}, err
+	}
+	var r Result
+	err = c.do(ctx, "POST", "/hash", body, "application/json", &r,
+		http.StatusOK)
+	return r, err
+}
+
+// Get -- the hash stored under key.  While it is being hashed the Result
+// has a Status of queued or running and no Hash; see Wait.
+func (c *Client) Get(ctx context.Context, key string) (Result, error) {
+	var r Result
+	err := c.do(ctx, "GET", "/hash/"+url.PathEscape(key), nil, "", &r,
+		http.StatusOK, http.StatusAccepted)
+	return r, err
+}
+
+// Wait -- Get the hash stored under key, polling until it has been
+// hashed or ctx is done
+func (c *Client) Wait(ctx context.Context, key string) (Result, error) {
+	delay := minPoll
+	for {
+		r, err := c.Get(ctx, key)
+		if err != nil || r.Status == StatusDone {
+			return r, err
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return r, err
+		}
+		delay = min(2*delay, maxPoll)
+	}
+}
+
+// Status -- the progress of the hash job of key
+func (c *Client) Status(ctx context.Context, key string) (JobStatus, error) {
+	var js JobStatus
+	err := c.do(ctx, "GET", "/hash/"+url.PathEscape(key)+"/status", nil, "",
+		&js, http.StatusOK)
+	return js, err
+}
+
+// Verify -- whether password is that hashed under key
+func (c *Client) Verify(ctx context.Context, key, password string) (VerifyResult, error) {
+	return c.verify(ctx, url.Values{"key": {key}, "password": {password}})
+}
+
+// VerifyHash -- whether password is that encoded, a hash as Get returns
+func (c *Client) VerifyHash(ctx context.Context, encoded, password string) (VerifyResult, error) {
+	return c.verify(ctx, url.Values{"hash": {encoded}, "password": {password}})
+}
+
+// verify -- POST /verify of form
+func (c *Client) verify(ctx context.Context, form url.Values) (VerifyResult, error) {
+	var v VerifyResult
+	err := c.do(ctx, "POST", "/verify", []byte(form.Encode()),
+		"application/x-www-form-urlencoded", &v, http.StatusOK)
+	return v, err
+}
+
+// Stats -- the server's statistics over window, one of "1m", "5m" or
+// "15m", or "" for since it started
+func (c *Client) Stats(ctx context.Context, window string) (Stats, error) {
+	path := "/stats"
+	if window != "" {
+		path += "?window=" + url.QueryEscape(window)
+	}
+	var st Stats
+	err := c.do(ctx, "GET", path, nil, "", &st, http.StatusOK)
+	return st, err
+}
+
+// Shutdown -- ask the server to stop taking passwords, and exit once the
+// queued ones are hashed
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.do(ctx, "PUT", "/shutdown", nil, "", nil, http.StatusAccepted)
+}
+
+// do -- make a request, retrying it while turned away with 429 or 503,
+// and decode the JSON answer into out if its status is one of ok
+func (c *Client) do(ctx context.Context, method, path string, body []byte,
+	contentType string, out any, ok ...int) error {
+	for attempt := 0; ; attempt++ {
+		status, data, header, err := c.send(ctx, method, path, body, contentType)
+		if err != nil {
+			return err
+		}
+		for _, s := range ok {
+			if status != s {
+				continue
+			}
+			if out == nil {
+				return nil
+			}
+			if err := json.Unmarshal(data, out); err != nil {
+				return fmt.Errorf("hashclient: %s %s: bad answer: %w", method,
+					path, err)
+			}
+			return nil
+		}
+		e := newError(status, data, header)
+		if !e.temporary() || attempt >= c.retries {
+			return e
+		}
+		wait := e.RetryAfter
+		if wait <= 0 {
+			wait = min(minBackoff<<attempt, maxBackoff)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return e // no time left to retry
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// send -- make a request once, returning the status, body and header of
+// the answer
+func (c *Client) send(ctx context.Context, method, path string, body []byte,
+	contentType string) (int, []byte, http.Header, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base.String()+path, rd)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("hashclient: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("hashclient: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("hashclient: %s %s: %w", method, path, err)
+	}
+	return resp.StatusCode, data, resp.Header, nil
+}
+
+// sleep -- wait for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
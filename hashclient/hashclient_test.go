@@ -0,0 +1,443 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of the client against a hashserver.Server under httptest: each
+// call, the retries of requests turned away, Wait, the errors of each
+// server error code, and that Stats matches the server's answer.
+//
+
+package hashclient_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stevewahl/GoTest/hashclient"
+	"github.com/stevewahl/GoTest/hashserver"
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+)
+
+// fastHasher -- a Hasher quick enough for tests
+func fastHasher(t *testing.T) passhash.Hasher {
+	t.Helper()
+	h, err := passhash.NewHasherParams(passhash.PBKDF2SHA512,
+		map[string]int{"i": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// gateHasher -- a Hasher whose Hash waits for gate to be closed, to keep
+// the hash workers busy
+type gateHasher struct {
+	passhash.Hasher
+	gate chan struct{}
+}
+
+func (g gateHasher) Hash(clearpw string) (string, error) {
+	<-g.gate
+	return g.Hasher.Hash(clearpw)
+}
+
+// failHasher -- a Hasher that always fails
+type failHasher struct{ passhash.Hasher }
+
+func (failHasher) Hash(string) (string, error) {
+	return "", errors.New("no hashing today")
+}
+
+// newServer -- the URL of a Server with a fast hasher and opts, counting
+// the requests made of it in *requests
+func newServer(t *testing.T, requests *atomic.Int64,
+	opts ...hashserver.Option) string {
+	t.Helper()
+	opts = append([]hashserver.Option{hashserver.WithHasher(fastHasher(t)),
+		hashserver.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))},
+		opts...)
+	hs, err := hashserver.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(rw http.ResponseWriter, req *http.Request) {
+			if requests != nil {
+				requests.Add(1)
+			}
+			hs.ServeHTTP(rw, req)
+		}))
+	t.Cleanup(func() {
+		ts.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := hs.Shutdown(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	return ts.URL
+}
+
+// newClient -- a Client of the server at url with opts
+func newClient(t *testing.T, url string,
+	opts ...hashclient.Option) *hashclient.Client {
+	t.Helper()
+	c, err := hashclient.New(url, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newServer(t, nil))
+	r, err := c.Hash(ctx, "angryMonkey")
+	if err != nil || r.Status != hashclient.StatusQueued || r.Key == "" {
+		t.Fatalf("Hash = %+v, %v", r, err)
+	}
+	r, err = c.Wait(ctx, r.Key)
+	if err != nil || r.Status != hashclient.StatusDone ||
+		!strings.HasPrefix(r.Hash, "$pbkdf2-sha512$i=1000$") ||
+		r.Algorithm != passhash.PBKDF2SHA512 {
+		t.Fatalf("Wait = %+v, %v", r, err)
+	}
+	if got, err := c.Get(ctx, r.Key); err != nil || got != r {
+		t.Errorf("Get = %+v, %v, want %+v", got, err, r)
+	}
+	js, err := c.Status(ctx, r.Key)
+	if err != nil || js.Status != hashclient.StatusDone || js.Finished == nil {
+		t.Errorf("Status = %+v, %v", js, err)
+	}
+	for _, tc := range []struct {
+		password string
+		match    bool
+	}{{"angryMonkey", true}, {"angryMonkey1", false}} {
+		v, err := c.Verify(ctx, r.Key, tc.password)
+		if err != nil || v.Match != tc.match || v.Key != r.Key {
+			t.Errorf("Verify(%s) = %+v, %v", tc.password, v, err)
+		}
+		v, err = c.VerifyHash(ctx, r.Hash, tc.password)
+		if err != nil || v.Match != tc.match {
+			t.Errorf("VerifyHash(%s) = %+v, %v", tc.password, v, err)
+		}
+	}
+
+	r, err = c.HashWith(ctx, "angryMonkey", passhash.Bcrypt,
+		map[string]int{"cost": 4})
+	if err == nil {
+		r, err = c.Wait(ctx, r.Key)
+	}
+	if err != nil || !strings.HasPrefix(r.Hash, "$2a$04$") {
+		t.Errorf("HashWith bcrypt = %+v, %v", r, err)
+	}
+	if _, err := c.HashWith(ctx, "x", "md5", nil); !errors.Is(err, hashclient.ErrUnknownAlgorithm) {
+		t.Errorf("HashWith md5: %v", err)
+	}
+	if _, err := c.Get(ctx, "00000000-0000-4000-8000-000000000000"); !errors.Is(err, hashclient.ErrNotFound) {
+		t.Errorf("Get of an unknown key: %v", err)
+	}
+
+	st, err := c.Stats(ctx, "")
+	if err != nil || st.Total != 2 || st.Latency.Count != 2 {
+		t.Errorf("Stats = %+v, %v", st, err)
+	}
+	if st, err := c.Stats(ctx, "5m"); err != nil || st.Window != "5m" {
+		t.Errorf("Stats(5m) = %+v, %v", st, err)
+	}
+	if _, err := c.Stats(ctx, "2h"); !errors.Is(err, hashclient.ErrBadRequest) {
+		t.Errorf("Stats(2h): %v", err)
+	}
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Hash(ctx, "angryMonkey"); !errors.Is(err, hashclient.ErrShuttingDown) {
+		t.Errorf("Hash after Shutdown: %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, url := range []string{"", "localhost:8088", "ftp://localhost",
+		"http://", "http://local host"} {
+		if _, err := hashclient.New(url); err == nil {
+			t.Errorf("New(%q) accepted", url)
+		}
+	}
+	if _, err := hashclient.New("http://localhost", hashclient.WithRetries(-1)); err == nil {
+		t.Error("WithRetries(-1) accepted")
+	}
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	ctx := context.Background()
+	var requests atomic.Int64
+	// a token a second, in bursts of 1
+	c := newClient(t, newServer(t, &requests, hashserver.WithLimits(1, 1, 4)))
+	if _, err := c.Hash(ctx, "angryMonkey"); err != nil {
+		t.Fatal(err)
+	}
+	// turned away with Retry-After: 1, and let in a second later
+	start := time.Now()
+	if _, err := c.Hash(ctx, "angryMonkey"); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); requests.Load() != 3 || took < 900*time.Millisecond {
+		t.Errorf("%d requests in %v, want 3 in a second", requests.Load(),
+			took)
+	}
+}
+
+func TestRetryLimit(t *testing.T) {
+	var requests atomic.Int64
+	// a token every 100 seconds
+	url := newServer(t, &requests, hashserver.WithLimits(0.01, 1, 4))
+	c := newClient(t, url)
+	ctx := context.Background()
+	if _, err := c.Hash(ctx, "angryMonkey"); err != nil {
+		t.Fatal(err)
+	}
+
+	// no retries
+	requests.Store(0)
+	_, err := newClient(t, url, hashclient.WithRetries(0)).Hash(ctx,
+		"angryMonkey")
+	var e *hashclient.Error
+	if !errors.Is(err, hashclient.ErrRateLimited) || !errors.As(err, &e) ||
+		e.RetryAfter < 90*time.Second || requests.Load() != 1 {
+		t.Errorf("Hash without retries: %v after %d requests", err,
+			requests.Load())
+	}
+
+	// not when the Retry-After would outlast the context
+	requests.Store(0)
+	dctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err = newClient(t, url).Hash(dctx, "angryMonkey")
+	if !errors.Is(err, hashclient.ErrRateLimited) || requests.Load() != 1 ||
+		time.Since(start) > time.Second {
+		t.Errorf("Hash with a short deadline: %v after %d requests in %v",
+			err, requests.Load(), time.Since(start))
+	}
+}
+
+func TestRetryBusy(t *testing.T) {
+	var requests atomic.Int64
+	gh := gateHasher{Hasher: fastHasher(t), gate: make(chan struct{})}
+	open := sync.OnceFunc(func() { close(gh.gate) })
+	defer open()
+	// a worker and no queue, so it takes one password at a time
+	url := newServer(t, &requests, hashserver.WithHasher(gh),
+		hashserver.WithWorkers(1, 0))
+	c := newClient(t, url)
+	ctx := context.Background()
+	if _, err := c.Hash(ctx, "angryMonkey"); err != nil {
+		t.Fatal(err)
+	}
+
+	// turned away with 503 and Retry-After: 1, as often as allowed
+	requests.Store(0)
+	start := time.Now()
+	_, err := newClient(t, url, hashclient.WithRetries(1)).Hash(ctx,
+		"angryMonkey2")
+	if !errors.Is(err, hashclient.ErrBusy) || requests.Load() != 2 ||
+		time.Since(start) < 900*time.Millisecond {
+		t.Errorf("Hash while busy: %v after %d requests in %v", err,
+			requests.Load(), time.Since(start))
+	}
+
+	// and let in once the worker is free
+	requests.Store(0)
+	time.AfterFunc(200*time.Millisecond, open)
+	_, err = c.Hash(ctx, "angryMonkey2")
+	if err != nil || requests.Load() != 2 {
+		t.Errorf("Hash once free: %v after %d requests", err,
+			requests.Load())
+	}
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+	gh := gateHasher{Hasher: fastHasher(t), gate: make(chan struct{})}
+	c := newClient(t, newServer(t, nil, hashserver.WithHasher(gh),
+		hashserver.WithWorkers(1, 10),
+		hashserver.WithJobTimeout(50*time.Millisecond)))
+	first, err := c.Hash(ctx, "angryMonkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Hash(ctx, "angryMonkey2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// polling while the first is hashed, until the second has expired
+	time.AfterFunc(100*time.Millisecond, func() { close(gh.gate) })
+	if r, err := c.Wait(ctx, first.Key); err != nil || r.Status != hashclient.StatusDone {
+		t.Errorf("Wait = %+v, %v", r, err)
+	}
+	if r, err := c.Wait(ctx, second.Key); !errors.Is(err, hashclient.ErrJobExpired) {
+		t.Errorf("Wait of an expired job = %+v, %v", r, err)
+	}
+
+	// polling until ctx is done
+	gh2 := gateHasher{Hasher: fastHasher(t), gate: make(chan struct{})}
+	defer close(gh2.gate)
+	c = newClient(t, newServer(t, nil, hashserver.WithHasher(gh2)))
+	r, err := c.Hash(ctx, "angryMonkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if r, err := c.Wait(dctx, r.Key); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait past the deadline = %+v, %v", r, err)
+	}
+}
+
+func TestWaitFailed(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newServer(t, nil,
+		hashserver.WithHasher(failHasher{fastHasher(t)})))
+	r, err := c.Hash(ctx, "angryMonkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := c.Wait(ctx, r.Key); !errors.Is(err, hashclient.ErrHashFailed) {
+		t.Errorf("Wait of a failed job = %+v, %v", r, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	errs := map[string]*hashclient.Error{
+		"bad_request":            hashclient.ErrBadRequest,
+		"invalid_key":            hashclient.ErrInvalidKey,
+		"unknown_algorithm":      hashclient.ErrUnknownAlgorithm,
+		"bad_params":             hashclient.ErrBadParams,
+		"not_allowed":            hashclient.ErrNotAllowed,
+		"bad_hash_format":        hashclient.ErrBadHash,
+		"unauthorized":           hashclient.ErrUnauthorized,
+		"forbidden":              hashclient.ErrForbidden,
+		"not_found":              hashclient.ErrNotFound,
+		"method_not_allowed":     hashclient.ErrBadMethod,
+		"job_expired":            hashclient.ErrJobExpired,
+		"request_too_large":      hashclient.ErrTooLarge,
+		"unsupported_media_type": hashclient.ErrBadMediaType,
+		"shutting_down":          hashclient.ErrShuttingDown,
+		"rate_limited":           hashclient.ErrRateLimited,
+		"hash_failed":            hashclient.ErrHashFailed,
+		"internal_error":         hashclient.ErrInternal,
+		"busy":                   hashclient.ErrBusy,
+	}
+	// a server answering GET /hash/{code} with an error of that code, and
+	// GET /hash/{status} with that status in plain text, as no2 to no5 do
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(rw http.ResponseWriter, req *http.Request) {
+			arg := strings.TrimPrefix(req.URL.Path, "/hash/")
+			if e, ok := errs[arg]; ok {
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(rw).Encode(map[string]any{"error": "oops " + arg,
+					"code": e.Code, "status": http.StatusBadRequest})
+				return
+			}
+			var status int
+			json.Unmarshal([]byte(arg), &status)
+			rw.Header().Set("Retry-After", "7")
+			http.Error(rw, "oops", status)
+		}))
+	defer ts.Close()
+	c, err := hashclient.New(ts.URL, hashclient.WithRetries(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for code, want := range errs {
+		_, err := c.Get(ctx, code)
+		var e *hashclient.Error
+		if !errors.As(err, &e) || !errors.Is(err, want) ||
+			e.StatusCode != http.StatusBadRequest || e.Message != "oops "+code {
+			t.Errorf("code %s: %#v, want %v", code, err, want)
+		}
+		for other, o := range errs {
+			if other != code && errors.Is(err, o) {
+				t.Errorf("code %s: %v is also %s", code, err, other)
+			}
+		}
+	}
+	for status, want := range map[int]*hashclient.Error{
+		400: hashclient.ErrBadRequest, 401: hashclient.ErrUnauthorized,
+		403: hashclient.ErrForbidden, 404: hashclient.ErrNotFound,
+		405: hashclient.ErrBadMethod, 410: hashclient.ErrJobExpired,
+		413: hashclient.ErrTooLarge, 415: hashclient.ErrBadMediaType,
+		417: hashclient.ErrShuttingDown, 429: hashclient.ErrRateLimited,
+		500: hashclient.ErrInternal, 503: hashclient.ErrBusy,
+		418: {Code: "http_418"},
+	} {
+		key, _ := json.Marshal(status)
+		_, err := c.Get(ctx, string(key))
+		var e *hashclient.Error
+		if !errors.As(err, &e) || !errors.Is(err, want) ||
+			e.StatusCode != status || e.Message != "oops" ||
+			e.RetryAfter != 7*time.Second {
+			t.Errorf("plain text %d: %#v, want %v", status, err, want)
+		}
+	}
+}
+
+// filled -- fail unless every field of the struct v is set, so that a
+// field added to it must be added to the test too
+func filled(t *testing.T, v any) {
+	t.Helper()
+	rv := reflect.ValueOf(v)
+	for i := range rv.NumField() {
+		if rv.Field(i).IsZero() {
+			t.Errorf("%s.%s not set", rv.Type(), rv.Type().Field(i).Name)
+		}
+	}
+}
+
+func TestStatsJSON(t *testing.T) {
+	// the server's Stats, every field set
+	ss := hashserver.Stats{Total: 5, Average: 1234, Stored: 2, Window: "5m",
+		InFlight: 1, Queued: 3, QueueCap: 100,
+		Requests: map[string]hashserver.EndpointStats{
+			"/hash": {Count: 6, Errors: map[int]int{400: 1}}},
+		Errors:  map[int]int{400: 1},
+		Latency: hashserver.LatencyStats{Count: 5, P50: 1, P90: 2, P99: 3, Max: 4},
+		Limited: 2, Hashing: 1, HashCap: 4}
+	filled(t, ss)
+	filled(t, ss.Latency)
+	filled(t, ss.Requests["/hash"])
+	data, err := json.Marshal(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// read by the client, with nothing left over, and written back the same
+	var cs hashclient.Stats
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cs); err != nil {
+		t.Fatalf("hashclient.Stats of %s: %v", data, err)
+	}
+	back, err := json.Marshal(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back, data) {
+		t.Errorf("hashserver.Stats\n%s\nread by the client as\n%s", data, back)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Auditing.  Given an audit log (WithAuditLog, -auditlog) every POST
+// /hash, GET /hash/{key}, POST /verify and PUT /shutdown, and every
+// request turned away for want of credentials or role, is recorded in a
+// hash-chained audit log: who (credential name or TLS client identity),
+// from where, which key, and the outcome.  See the audit package, and
+// auditVerifyCmd to check the chain.
+//
+
+package hashserver
+
+import (
+	"errors"
+	"github.com/stevewahl/GoTest/audit"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"net"
+	"net/http"
+)
+
+// actorOf -- who made req: the name of its credential, else its TLS client
+// identity, "" if unknown
+func actorOf(req *http.Request) string {
+	if c := credentialOf(req); c != nil {
+		return c.Name
+	}
+	return svrconfig.ClientIdentity(req)
+}
+
+// auditEvent -- record event of req on key, with its outcome
+func (s *Server) auditEvent(req *http.Request, event, key, outcome string) {
+	if s.auditLog == nil {
+		return
+	}
+	client, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		client = req.RemoteAddr
+	}
+	err = s.auditLog.Record(audit.Record{Event: event, Actor: actorOf(req),
+		Client: client, Key: key, Outcome: outcome,
+		RequestID: svrconfig.RequestID(req)})
+	switch {
+	case errors.Is(err, audit.ErrNotRotated):
+		s.logger.Warn("audit log not rotated", "err", err)
+	case err != nil:
+		s.logger.Error("unable to write audit record", "event", event,
+			"key", key, "err", err)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Authentication and authorization.  Without an auth file (WithAuthFile,
+// -authfile) anyone reaching the port may do anything.  With it, each
+// request must carry an API key ("X-API-Key: <token>"), a bearer token
+// ("Authorization: Bearer <token>") or a TLS client certificate of a
+// client listed in the file, with the role the endpoint needs:
+//
+//    submitter  -- POST /hash
+//    reader     -- GET /hash/{key}, GET /hash/{key}/status, POST /verify
+//    admin      -- all of the above, GET /stats, GET /metrics, PUT /shutdown
+//
+//    $ cat /etc/hashpw-auth.json
+//    [{"name": "signup", "token": "6f1c...e9", "roles": ["submitter"]},
+//     {"name": "login", "token": "0a7d...41", "roles": ["reader"]},
+//     {"name": "ops", "client": "alice", "roles": ["admin"]}]
+//
+// Requests without known credentials get 401, those lacking the role 403.
+//
+
+package hashserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/stevewahl/GoTest/audit"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// roles of clients
+const (
+	roleSubmitter = "submitter"
+	roleReader    = "reader"
+	roleAdmin     = "admin"
+)
+
+// minTokenLen -- shortest token accepted in the auth file
+const minTokenLen = 16
+
+// credential -- a client allowed in, from the auth file
+type credential struct {
+	Name   string   `json:"name"`
+	Token  string   `json:"token,omitempty"`  // API key or bearer token
+	Client string   `json:"client,omitempty"` // or TLS client identity
+	Roles  []string `json:"roles"`
+}
+
+// loadCredentials -- read the credentials of the auth file path
+func loadCredentials(path string) ([]credential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var creds []credential
+	// refuse misspelt fields, rather than let in a client without its roles
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&creds); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i, c := range creds {
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("%s: credential %d has no name", path, i)
+		case (c.Token == "") == (c.Client == ""):
+			return nil, fmt.Errorf("%s: %s needs either a token or a client",
+				path, c.Name)
+		case c.Token != "" && len(c.Token) < minTokenLen:
+			return nil, fmt.Errorf("%s: token of %s shorter than %d", path,
+				c.Name, minTokenLen)
+		}
+		for _, role := range c.Roles {
+			if role != roleSubmitter && role != roleReader && role != roleAdmin {
+				return nil, fmt.Errorf("%s: %s has unknown role %q", path,
+					c.Name, role)
+			}
+		}
+	}
+	if creds == nil {
+		creds = []credential{}
+	}
+	return creds, nil
+}
+
+// requestToken -- the API key or bearer token of req, "" if none
+func requestToken(req *http.Request) string {
+	if key := req.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// authenticate -- the credential req presents, nil if none known
+func (s *Server) authenticate(req *http.Request) *credential {
+	if token := requestToken(req); token != "" {
+		// compare digests, so every comparison takes the same time
+		sum := sha256.Sum256([]byte(token))
+		var found *credential
+		for i := range s.credentials {
+			c := &s.credentials[i]
+			csum := sha256.Sum256([]byte(c.Token))
+			if c.Token != "" && subtle.ConstantTimeCompare(sum[:], csum[:]) == 1 {
+				found = c
+			}
+		}
+		return found
+	}
+	if client := svrconfig.ClientIdentity(req); client != "" {
+		for i := range s.credentials {
+			if s.credentials[i].Client == client {
+				return &s.credentials[i]
+			}
+		}
+	}
+	return nil
+}
+
+// has -- whether c has role, admins having every role
+func (c *credential) has(role string) bool {
+	for _, r := range c.Roles {
+		if r == role || r == roleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// authorized -- handler wrapping h, letting in only clients with role
+func (s *Server) authorized(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if s.credentials == nil {
+			h(rw, req)
+			return
+		}
+		c := s.authenticate(req)
+		if c == nil {
+			svrconfig.RequestLogger(req).Warn("unauthenticated request",
+				"path", req.URL.Path)
+			s.auditEvent(req, audit.EventAccessDenied, "", "unauthenticated")
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="hashpw"`)
+			writeError(rw, req, http.StatusUnauthorized, codeUnauthorized,
+				"API key, bearer token or client certificate required")
+			return
+		}
+		req = req.WithContext(context.WithValue(req.Context(), credKey{}, c))
+		if !c.has(role) {
+			svrconfig.RequestLogger(req).Warn("credential lacks role",
+				"credential", c.Name, "role", role, "path", req.URL.Path)
+			s.auditEvent(req, audit.EventAccessDenied, "", "forbidden_"+role)
+			writeError(rw, req, http.StatusForbidden, codeForbidden,
+				"the "+role+" role is required")
+			return
+		}
+		h(rw, req)
+	}
+}
+
+// credKey -- context key of the credential of an authorized request
+type credKey struct{}
+
+// credentialOf -- the credential req was authorized by, nil if none
+func credentialOf(req *http.Request) *credential {
+	c, _ := req.Context().Value(credKey{}).(*credential)
+	return c
+}
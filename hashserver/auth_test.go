@@ -0,0 +1,116 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of authentication and authorization: who is let in to which
+// endpoints, and which auth files are refused.
+//
+
+package hashserver_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stevewahl/GoTest/hashserver"
+)
+
+func TestUnauthenticated(t *testing.T) {
+	_, ts := newServer(t, hashserver.WithAuthFile(authFile(t, creds)))
+	for what, header := range map[string][]string{
+		"no credentials":  nil,
+		"unknown API key": {"X-API-Key", "nosuch-0123456789"},
+		"unknown token":   {"Authorization", "Bearer nosuch-0123456789"},
+		"basic auth":      {"Authorization", "Basic " + opsToken},
+		"token prefix":    {"X-API-Key", opsToken[:8]},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+"/stats", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized ||
+			!strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Bearer ") {
+			t.Errorf("%s: %s, WWW-Authenticate %q, want 401", what,
+				resp.Status, resp.Header.Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	_, ts := newServer(t, hashserver.WithAuthFile(authFile(t, creds)))
+	key := "00000000-0000-4000-8000-000000000000"
+	// the endpoints, with the clients let in; PUT /shutdown last
+	endpoints := []struct {
+		method, path string
+		form         url.Values
+		clients      string
+	}{
+		{"POST", "/hash", url.Values{"password": {"angryMonkey"}},
+			"signup ops"},
+		{"GET", "/hash/" + key, nil, "login ops"},
+		{"GET", "/hash/" + key + "/status", nil, "login ops"},
+		{"POST", "/verify", url.Values{"key": {key}, "password": {"x"}},
+			"login ops"},
+		{"GET", "/stats", nil, "ops"},
+		{"GET", "/metrics", nil, "ops"},
+		{"PUT", "/shutdown", nil, "ops"},
+	}
+	tokens := []struct{ name, token string }{
+		{"signup", signupToken}, {"login", loginToken}, {"ops", opsToken},
+	}
+	for _, ep := range endpoints {
+		for _, c := range tokens {
+			what := ep.method + " " + ep.path + " by " + c.name
+			status, body := do(t, ts, ep.method, ep.path, ep.form,
+				"Authorization", "Bearer "+c.token, "Accept", "application/json")
+			if strings.Contains(ep.clients, c.name) {
+				if status == http.StatusUnauthorized ||
+					status == http.StatusForbidden {
+					t.Errorf("%s: %d %q, want let in", what, status, body)
+				}
+				continue
+			}
+			expectError(t, what, status, body, 403, "forbidden")
+		}
+	}
+}
+
+func TestAuthFileRefused(t *testing.T) {
+	for what, data := range map[string]string{
+		"not JSON":         `{"name": "signup"`,
+		"not a list":       `{"name": "signup", "token": "` + signupToken + `"}`,
+		"no name":          `[{"token": "` + signupToken + `", "roles": ["submitter"]}]`,
+		"token and client": `[{"name": "ops", "token": "` + opsToken + `", "client": "alice", "roles": ["admin"]}]`,
+		"neither":          `[{"name": "ops", "roles": ["admin"]}]`,
+		"short token":      `[{"name": "ops", "token": "0123456789", "roles": ["admin"]}]`,
+		"unknown role":     `[{"name": "ops", "token": "` + opsToken + `", "roles": ["root"]}]`,
+		"unknown field":    `[{"name": "ops", "token": "` + opsToken + `", "role": "admin"}]`,
+	} {
+		if _, err := hashserver.New(hashserver.WithAuthFile(authFile(t, data))); err == nil {
+			t.Errorf("%s: auth file %s accepted", what, data)
+		}
+	}
+	if _, err := hashserver.New(hashserver.WithAuthFile("/nonexistent/auth.json")); err == nil {
+		t.Error("missing auth file accepted")
+	}
+
+	// an empty list lets no one in
+	_, ts := newServer(t, hashserver.WithAuthFile(authFile(t, `[]`)))
+	status, body := do(t, ts, "GET", "/stats", nil, "X-API-Key", opsToken,
+		"Accept", "application/json")
+	expectError(t, "GET /stats with no clients", status, body, 401,
+		"unauthorized")
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Handlers of the endpoints, see httpHashPWsvr_no6 for their use.
+//
+
+package hashserver
+
+import (
+	"fmt"
+	"github.com/stevewahl/GoTest/audit"
+	"github.com/stevewahl/GoTest/hashstore"
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats -- JSON response of GET /stats, see stats.go
+type Stats struct {
+	Total    int                      `json:"total"`            // number of hash requests
+	Average  int                      `json:"average"`          // mean microseconds per hash request
+	Stored   int                      `json:"stored,omitempty"` // of Total, those stored before start
+	Window   string                   `json:"window,omitempty"`
+	InFlight int                      `json:"in_flight"`   // requests being served
+	Queued   int                      `json:"queue_depth"` // passwords waiting for a worker
+	QueueCap int                      `json:"queue_capacity"`
+	Requests map[string]EndpointStats `json:"requests"` // by endpoint
+	Errors   map[int]int              `json:"errors"`   // by status code
+	Latency  LatencyStats             `json:"latency"`
+	Limited  int                      `json:"rate_limited"` // requests turned away, see ratelimit.go
+	Hashing  int                      `json:"hashing"`      // passwords being hashed or verified
+	HashCap  int                      `json:"hashing_capacity"`
+}
+
+// storeHash -- save a hashed password under key, logging any failure
+func (s *Server) storeHash(key string, pwhash string) {
+	if err := s.store.Put(key, pwhash); err != nil {
+		s.logger.Error("unable to store hashed password", "key", key,
+			"err", err)
+	}
+}
+
+// storedHash -- the hashed password saved under key, "" if none
+func (s *Server) storedHash(key string) string {
+	pwhash, _, err := s.store.Get(key)
+	if err != nil {
+		s.logger.Error("unable to read hashed password", "key", key,
+			"err", err)
+	}
+	return pwhash
+}
+
+// hashGetReq -- GET response handler to retrieve stored hashed passwords,
+// or with /hash/{key}/status the status of the hash job
+func (s *Server) hashGetReq(rw http.ResponseWriter, req *http.Request) {
+	lg := svrconfig.RequestLogger(req)
+	flusher := rw.(http.Flusher)
+	req.ParseForm()
+	key := ""
+	// return a previously generated hashed password string.
+	if req.Method == "GET" {
+		pathArray := strings.Split(req.URL.Path, "/")
+		pathlen := len(pathArray)
+		if pathlen == 3 || (pathlen == 4 && pathArray[3] == "status") {
+			key = pathArray[2]
+		}
+		if !hashstore.ValidKey(key) {
+			lg.Warn("GET missing or invalid hashed password key")
+			writeError(rw, req, http.StatusBadRequest, codeInvalidKey,
+				"GET method missing or invalid Hashed Password key")
+			return
+		}
+		if pathlen == 4 {
+			s.statusGetReq(rw, req, key)
+			return
+		}
+		js, known := s.statusOf(key)
+		switch {
+		case known && (js.Status == statusQueued || js.Status == statusRunning):
+			s.auditEvent(req, audit.EventRetrieve, key, "pending")
+			if wantsJSON(req) {
+				writeJSON(rw, req, http.StatusAccepted,
+					hashBody{Key: key, Status: js.Status})
+				return
+			}
+			rw.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(rw, "pending\n")
+		case known && js.Status == statusFailed:
+			s.auditEvent(req, audit.EventRetrieve, key, "failed")
+			writeError(rw, req, http.StatusInternalServerError, codeHashFailed,
+				"Hashing of password failed")
+		case known && js.Status == statusExpired:
+			s.auditEvent(req, audit.EventRetrieve, key, "expired")
+			writeError(rw, req, http.StatusGone, codeJobExpired,
+				"Hash job expired before it was run")
+		default:
+			pwhash := s.storedHash(key)
+			if len(pwhash) == 0 {
+				s.auditEvent(req, audit.EventRetrieve, key, "not_found")
+				writeError(rw, req, http.StatusNotFound, codeNotFound,
+					"No Hashed Password for key")
+				return
+			}
+			s.auditEvent(req, audit.EventRetrieve, key, "found")
+			if wantsJSON(req) {
+				writeJSON(rw, req, http.StatusOK, hashBody{Key: key,
+					Status: statusDone, Hash: pwhash,
+					Algorithm: passhash.AlgorithmOf(pwhash)})
+			} else {
+				fmt.Fprint(rw, pwhash, "\n")
+				flusher.Flush()
+			}
+		}
+	} else {
+		writeError(rw, req, http.StatusMethodNotAllowed, codeBadMethod,
+			"ERROR in request to /hash. Must be POST or GET")
+		lg.Warn("non GET method given to /hash/ request",
+			"method", req.Method)
+	}
+}
+
+// statusGetReq -- return JSON packet of the status of the hash job for key
+func (s *Server) statusGetReq(rw http.ResponseWriter, req *http.Request, key string) {
+	js, known := s.statusOf(key)
+	if !known {
+		// status long forgotten, or from before a restart: done if stored
+		if len(s.storedHash(key)) == 0 {
+			writeError(rw, req, http.StatusNotFound, codeNotFound,
+				"No hash job for key")
+			return
+		}
+		js = jobStatus{Key: key, Status: statusDone}
+	}
+	writeJSON(rw, req, http.StatusOK, js)
+}
+
+// hashPostReq -- POST response handler to hash and store password, returning key
+func (s *Server) hashPostReq(rw http.ResponseWriter, req *http.Request) {
+	lg := svrconfig.RequestLogger(req)
+	flusher := rw.(http.Flusher)
+	req.ParseForm()
+	if req.Method == "POST" {
+		// see if server is no longer accepting new requests
+		if !s.st.accepting() {
+			lg.Warn("server not accepting new requests at this time")
+			s.auditEvent(req, audit.EventHash, "", "rejected_shutting_down")
+			writeError(rw, req, http.StatusExpectationFailed, codeShuttingDown,
+				"Server not accepting new connections at this time.")
+		} else {
+			// process the POST request
+			starttime := time.Now()
+			pw, h, ok := s.readHashRequest(rw, req)
+			if !ok {
+				return
+			}
+			// make and return the retrieval key for this to-be hashed password
+			key, err := hashstore.NewKey(s.keyScheme)
+			if err != nil {
+				lg.Error("unable to make a key", "err", err)
+				writeError(rw, req, http.StatusInternalServerError, codeInternal,
+					"unable to make a key")
+				return
+			}
+			// count the job outstanding before a worker can finish it
+			s.st.jobQueued()
+			// hand the password to the hash workers, see hashjobs.go
+			if !s.enqueueJob(lg, key, pw, h, starttime) {
+				s.st.jobTurnedAway()
+				lg.Warn("hash job queue full, POST turned away")
+				s.auditEvent(req, audit.EventHash, key, "rejected_busy")
+				rw.Header().Set("Retry-After", strconv.Itoa(s.retryAfter()))
+				writeError(rw, req, http.StatusServiceUnavailable, codeBusy,
+					"Server busy, try again later.")
+				return
+			}
+			s.st.jobAccepted()
+			s.auditEvent(req, audit.EventHash, key, "accepted")
+			if wantsJSON(req) {
+				writeJSON(rw, req, http.StatusOK, hashBody{Key: key,
+					Status: statusQueued, Algorithm: h.Name()})
+				return
+			}
+			fmt.Fprint(rw, key, "\n")
+			flusher.Flush()
+		}
+	} else {
+		writeError(rw, req, http.StatusMethodNotAllowed, codeBadMethod,
+			"ERROR in request to /hash. Must be POST")
+		lg.Warn("non POST method given to /hash request",
+			"method", req.Method)
+	}
+}
+
+// verifyPostReq -- POST response handler to check a password against a stored
+// hashed password, given either its key or the encoded hash
+func (s *Server) verifyPostReq(rw http.ResponseWriter, req *http.Request) {
+	lg := svrconfig.RequestLogger(req)
+	req.ParseForm()
+	if req.Method != "POST" {
+		writeError(rw, req, http.StatusMethodNotAllowed, codeBadMethod,
+			"ERROR in request to /verify. Must be POST")
+		lg.Warn("non POST method given to /verify request",
+			"method", req.Method)
+		return
+	}
+	pw := req.Form.Get("password")
+	encoded := req.Form.Get("hash")
+	if len(pw) == 0 || (len(encoded) == 0) == (len(req.Form.Get("key")) == 0) {
+		lg.Warn("POST /verify body missing password, or key/hash")
+		writeError(rw, req, http.StatusBadRequest, codeBadRequest,
+			"expecting body of: \"password=<string>\" and one of "+
+				"\"key=<key>\" or \"hash=<encoded hash>\"")
+		return
+	}
+	key := req.Form.Get("key")
+	if len(key) > 0 {
+		if !hashstore.ValidKey(key) {
+			lg.Warn("/verify missing or invalid hashed password key")
+			writeError(rw, req, http.StatusBadRequest, codeInvalidKey,
+				"missing or invalid Hashed Password key")
+			return
+		}
+		encoded = s.storedHash(key)
+		if len(encoded) == 0 {
+			s.auditEvent(req, audit.EventVerify, key, "not_found")
+			writeError(rw, req, http.StatusNotFound, codeNotFound,
+				"Hashed Password not yet available for key")
+			return
+		}
+	} else if h, err := passhash.HasherOf(encoded); err == nil {
+		// a client's hash may not cost more to check than the policy
+		// lets clients ask hashing with
+		if err := s.policy.Within(h); err != nil {
+			lg.Warn("/verify hash beyond the hashing policy", "err", err)
+			s.auditEvent(req, audit.EventVerify, key, "not_allowed")
+			writeError(rw, req, http.StatusBadRequest, codeNotAllowed,
+				err.Error())
+			return
+		}
+	}
+	if !s.tryHashSlot() {
+		lg.Warn("all hashing slots busy, /verify turned away")
+		s.auditEvent(req, audit.EventVerify, key, "rejected_busy")
+		rw.Header().Set("Retry-After", "1")
+		writeError(rw, req, http.StatusTooManyRequests, codeBusy,
+			"Server busy, try again later.")
+		return
+	}
+	defer s.releaseHashSlot()
+	match, err := passhash.Verify(pw, encoded)
+	if err != nil {
+		lg.Warn("/verify unable to check hash", "err", err)
+		s.auditEvent(req, audit.EventVerify, key, "bad_hash")
+		writeError(rw, req, http.StatusBadRequest, codeBadHash,
+			"unrecognized Hashed Password format")
+		return
+	}
+	lg.Info("verified", "key", key, "match", match)
+	if match {
+		s.auditEvent(req, audit.EventVerify, key, "match")
+	} else {
+		s.auditEvent(req, audit.EventVerify, key, "no_match")
+	}
+	if vr, ok := s.store.(hashstore.VerifyRecorder); ok && match && len(key) > 0 {
+		if err := vr.MarkVerified(key, time.Now()); err != nil {
+			lg.Error("unable to record verification", "key", key,
+				"err", err)
+		}
+	}
+	rehashed := false
+	if match && len(key) > 0 && passhash.NeedsRehash(encoded, s.hasher) {
+		// migrate the stored hash to the current hashing policy
+		if pwhash, err := s.hasher.Hash(pw); err != nil {
+			lg.Error("unable to rehash password", "key", key, "err", err)
+		} else {
+			s.storeHash(key, pwhash)
+			rehashed = true
+			lg.Info("rehashed", "key", key, "algorithm", s.hasher.Name())
+		}
+	}
+	if wantsJSON(req) {
+		writeJSON(rw, req, http.StatusOK,
+			verifyBody{Match: match, Key: key, Rehashed: rehashed})
+	} else if match {
+		fmt.Fprint(rw, "match\n")
+	} else {
+		fmt.Fprint(rw, "no match\n")
+	}
+}
+
+// statsGetReq -- return JSON packet of hash requests count and average
+// milliseconds per request
+func (s *Server) statsGetReq(rw http.ResponseWriter, req *http.Request) {
+	lg := svrconfig.RequestLogger(req)
+	req.ParseForm()
+	if req.Method == "GET" {
+		stats, err := s.Stats(req.Form.Get("window"))
+		if err != nil {
+			writeError(rw, req, http.StatusBadRequest, codeBadRequest,
+				"window must be one of 1m, 5m or 15m")
+			return
+		}
+		writeJSON(rw, req, http.StatusOK, stats)
+	} else {
+		writeError(rw, req, http.StatusMethodNotAllowed, codeBadMethod,
+			"ERROR in request to /stats. Must be GET method")
+		lg.Warn("non GET method given to /stats request",
+			"method", req.Method)
+	}
+}
+
+// shutPutReq -- PUT response handler to allow no more password requests
+func (s *Server) shutPutReq(rw http.ResponseWriter, req *http.Request) {
+	lg := svrconfig.RequestLogger(req)
+	req.ParseForm()
+	if req.Method != "PUT" {
+		writeError(rw, req, http.StatusMethodNotAllowed, codeBadMethod,
+			"ERROR in request to /shutdown. Must be PUT")
+		lg.Warn("non PUT method given to /shutdown request",
+			"method", req.Method)
+		return
+	}
+	// set the server to no longer accepting new request; whoever runs it
+	// drains and stops it once this response is sent, see Shutdown
+	if client := svrconfig.ClientIdentity(req); client != "" {
+		lg.Info("shutdown requested by client", "client", client)
+	}
+	lg.Info("server not accepting new requests at this time")
+	s.auditEvent(req, audit.EventShutdown, "", "accepted")
+	s.st.stopAccepting()
+	msg := "Server no longer accepting new requests and exiting " +
+		"once outstanding hash jobs are done."
+	if wantsJSON(req) {
+		writeJSON(rw, req, http.StatusAccepted,
+			shutdownBody{Status: "shutting_down", Message: msg})
+		return
+	}
+	rw.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(rw, msg, "\n")
+}
@@ -0,0 +1,579 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of the endpoints of a Server through httptest: the answers in
+// text and JSON, the error paths and the method checks, and with
+// credentials, rate limits and an audit log.  See helpers_test.go.
+//
+
+package hashserver_test
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevewahl/GoTest/audit"
+	"github.com/stevewahl/GoTest/hashserver"
+	"github.com/stevewahl/GoTest/hashstore"
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+)
+
+func TestHashForm(t *testing.T) {
+	_, ts := newServer(t)
+	key := hashPassword(t, ts, "angryMonkey")
+	status, body := do(t, ts, "GET", "/hash/"+key, nil)
+	if status != http.StatusOK ||
+		!strings.HasPrefix(body, "$pbkdf2-sha512$i=1000$") {
+		t.Fatalf("GET /hash/{key}: %d %q", status, body)
+	}
+	if ok, err := passhash.Verify("angryMonkey", strings.TrimSpace(body)); !ok {
+		t.Errorf("stored hash does not verify: %v", err)
+	}
+
+	status, body = do(t, ts, "GET", "/hash/"+key, nil, asJSON...)
+	var hb struct{ Key, Status, Hash, Algorithm string }
+	decode(t, body, &hb)
+	if status != http.StatusOK || hb.Key != key || hb.Status != "done" ||
+		hb.Algorithm != passhash.PBKDF2SHA512 || hb.Hash == "" {
+		t.Errorf("GET /hash/{key} as JSON: %d %q", status, body)
+	}
+}
+
+func TestHashJSON(t *testing.T) {
+	_, ts := newServer(t)
+	for _, tc := range []struct{ body, algorithm, prefix string }{
+		{`{"password": "angryMonkey"}`, passhash.PBKDF2SHA512,
+			"$pbkdf2-sha512$i=1000$"},
+		{`{"password": "angryMonkey", "params": {"i": 2000}}`,
+			passhash.PBKDF2SHA512, "$pbkdf2-sha512$i=2000$"},
+		{`{"password": "angryMonkey", "algorithm": "bcrypt", "params": {"cost": 4}}`,
+			passhash.Bcrypt, "$2a$04$"},
+	} {
+		status, body := postBody(t, ts, "/hash", "application/json", tc.body)
+		var hb struct{ Key, Status, Algorithm string }
+		decode(t, body, &hb)
+		if status != http.StatusOK || hb.Status != "queued" ||
+			hb.Algorithm != tc.algorithm {
+			t.Errorf("POST /hash %s: %d %q", tc.body, status, body)
+			continue
+		}
+		waitHashed(t, ts, hb.Key)
+		status, body = do(t, ts, "GET", "/hash/"+hb.Key, nil)
+		if !strings.HasPrefix(body, tc.prefix) {
+			t.Errorf("POST /hash %s: hashed as %q, want %s...", tc.body,
+				body, tc.prefix)
+		}
+	}
+}
+
+func TestHashErrors(t *testing.T) {
+	_, ts := newServer(t)
+	form := "application/x-www-form-urlencoded"
+	for _, tc := range []struct {
+		what, ctype, body string
+		status            int
+		code              string
+	}{
+		{"no password", form, "", 400, "bad_request"},
+		{"empty password", form, "password=", 400, "bad_request"},
+		{"no JSON password", "application/json", `{}`, 400, "bad_request"},
+		{"malformed JSON", "application/json", `{"password": `, 400,
+			"bad_request"},
+		{"unknown field", "application/json", `{"password": "x", "pw": "y"}`,
+			400, "bad_request"},
+		{"trailing data", "application/json", `{"password": "x"} {}`, 400,
+			"bad_request"},
+		{"unknown algorithm", "application/json",
+			`{"password": "x", "algorithm": "md5"}`, 400, "unknown_algorithm"},
+		{"parameter out of range", "application/json",
+			`{"password": "x", "params": {"i": 1}}`, 400, "bad_params"},
+		{"unknown parameter", "application/json",
+			`{"password": "x", "params": {"cost": 10}}`, 400, "bad_params"},
+		{"algorithm not allowed", "application/json",
+			`{"password": "x", "algorithm": "sha512"}`, 400, "not_allowed"},
+		{"beyond the limits of Verify", "application/json",
+			`{"password": "x", "algorithm": "argon2id", "params": {"m": 262145}}`,
+			400, "bad_params"},
+		{"above the policy", "application/json",
+			`{"password": "x", "algorithm": "argon2id", "params": {"t": 5}}`,
+			400, "not_allowed"},
+		{"content type", "text/plain", "x", 415, "unsupported_media_type"},
+		{"too large", "application/json",
+			`{"password": "` + strings.Repeat("x", 70000) + `"}`, 413,
+			"request_too_large"},
+	} {
+		status, body := postBody(t, ts, "/hash", tc.ctype, tc.body)
+		expectError(t, "POST /hash "+tc.what, status, body, tc.status, tc.code)
+	}
+	status, body := do(t, ts, "GET", "/hash", nil, asJSON...)
+	expectError(t, "GET /hash", status, body, 405, "method_not_allowed")
+}
+
+func TestHashPolicy(t *testing.T) {
+	_, ts := newServer(t, hashserver.WithPolicy(passhash.Policy{
+		Allowed: []string{passhash.PBKDF2SHA512},
+		Max:     map[string]map[string]int{passhash.PBKDF2SHA512: {"i": 5000}},
+	}))
+	for body, code := range map[string]string{
+		`{"password": "x", "params": {"i": 5000}}`: "",
+		`{"password": "x", "params": {"i": 5001}}`: "not_allowed",
+		`{"password": "x", "algorithm": "bcrypt"}`: "not_allowed",
+		// the algorithm's defaults, not the server's parameters
+		`{"password": "x", "algorithm": "pbkdf2-sha512"}`:                "not_allowed",
+		`{"password": "x", "algorithm": "argon2id", "params": {"t": 1}}`: "not_allowed",
+	} {
+		status, answer := postBody(t, ts, "/hash", "application/json", body)
+		if code == "" {
+			if status != http.StatusOK {
+				t.Errorf("POST /hash %s: %d %q", body, status, answer)
+			}
+			continue
+		}
+		expectError(t, "POST /hash "+body, status, answer, 400, code)
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	_, ts := newServer(t)
+	unknown := "00000000-0000-4000-8000-000000000000"
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{"GET", "/hash/" + unknown, 404, "not_found"},
+		{"GET", "/hash/" + unknown + "/status", 404, "not_found"},
+		{"GET", "/hash/42", 404, "not_found"},
+		{"GET", "/hash/not-a-key", 400, "invalid_key"},
+		{"GET", "/hash/", 400, "invalid_key"},
+		{"GET", "/hash/" + unknown + "/nosuch", 400, "invalid_key"},
+		{"DELETE", "/hash/" + unknown, 405, "method_not_allowed"},
+	} {
+		status, body := do(t, ts, tc.method, tc.path, nil, asJSON...)
+		expectError(t, tc.method+" "+tc.path, status, body, tc.status, tc.code)
+	}
+}
+
+func TestGetLegacyKey(t *testing.T) {
+	store := hashstore.NewMemoryStore()
+	legacy := passhash.HashifyPW("angryMonkey")
+	// 0 is the first key the integer key servers handed out
+	keys := []string{"0", "42"}
+	for _, key := range keys {
+		if err := store.Put(key, legacy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, ts := newServer(t, hashserver.WithStore(store))
+	for _, key := range keys {
+		status, body := do(t, ts, "GET", "/hash/"+key, nil)
+		if status != http.StatusOK || strings.TrimSpace(body) != legacy {
+			t.Errorf("GET /hash/%s: %d %q", key, status, body)
+		}
+		status, body = do(t, ts, "GET", "/hash/"+key+"/status", nil)
+		if status != http.StatusOK || !strings.Contains(body, `"status":"done"`) {
+			t.Errorf("GET /hash/%s/status: %d %q", key, status, body)
+		}
+		status, body = do(t, ts, "POST", "/verify",
+			url.Values{"key": {key}, "password": {"angryMonkey"}})
+		if status != http.StatusOK || body != "match\n" {
+			t.Errorf("POST /verify of %s: %d %q", key, status, body)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	_, ts := newServer(t)
+	key := hashPassword(t, ts, "angryMonkey")
+	status, body := do(t, ts, "GET", "/hash/"+key+"/status", nil)
+	var js struct{ Key, Status string }
+	decode(t, body, &js)
+	if status != http.StatusOK || js.Key != key || js.Status != "done" {
+		t.Errorf("GET /hash/{key}/status: %d %q", status, body)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	_, ts := newServer(t)
+	key := hashPassword(t, ts, "angryMonkey")
+	_, hash := do(t, ts, "GET", "/hash/"+key, nil)
+	hash = strings.TrimSpace(hash)
+	for _, tc := range []struct {
+		form url.Values
+		want string
+	}{
+		{url.Values{"key": {key}, "password": {"angryMonkey"}}, "match\n"},
+		{url.Values{"key": {key}, "password": {"angryMonkey1"}}, "no match\n"},
+		{url.Values{"hash": {hash}, "password": {"angryMonkey"}}, "match\n"},
+		{url.Values{"hash": {hash}, "password": {"angryMonkey1"}}, "no match\n"},
+		{url.Values{"key": {"42"}, "password": {"angryMonkey"}}, ""},
+	} {
+		status, body := do(t, ts, "POST", "/verify", tc.form)
+		if tc.want == "" {
+			if status != http.StatusNotFound {
+				t.Errorf("POST /verify %v: %d %q", tc.form, status, body)
+			}
+		} else if status != http.StatusOK || body != tc.want {
+			t.Errorf("POST /verify %v: %d %q, want %q", tc.form, status,
+				body, tc.want)
+		}
+	}
+	status, body := do(t, ts, "POST", "/verify",
+		url.Values{"key": {key}, "password": {"angryMonkey"}}, asJSON...)
+	var vb struct {
+		Match    bool
+		Key      string
+		Rehashed bool
+	}
+	decode(t, body, &vb)
+	if status != http.StatusOK || !vb.Match || vb.Key != key || vb.Rehashed {
+		t.Errorf("POST /verify as JSON: %d %q", status, body)
+	}
+}
+
+func TestVerifyRehash(t *testing.T) {
+	store := hashstore.NewMemoryStore()
+	old, err := passhash.BcryptHasher{Cost: 4}.Hash("angryMonkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := hashstore.NewKey(hashstore.DefaultKeyScheme)
+	if err := store.Put(key, old); err != nil {
+		t.Fatal(err)
+	}
+	_, ts := newServer(t, hashserver.WithStore(store))
+	status, body := do(t, ts, "POST", "/verify",
+		url.Values{"key": {key}, "password": {"angryMonkey"}}, asJSON...)
+	if status != http.StatusOK || !strings.Contains(body, `"rehashed":true`) {
+		t.Fatalf("POST /verify: %d %q", status, body)
+	}
+	stored, _, _ := store.Get(key)
+	if passhash.AlgorithmOf(stored) != passhash.PBKDF2SHA512 {
+		t.Errorf("stored hash %q not rehashed", stored)
+	}
+	if ok, _ := passhash.Verify("angryMonkey", stored); !ok {
+		t.Errorf("rehashed %q does not verify", stored)
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	_, ts := newServer(t)
+	for _, tc := range []struct {
+		what   string
+		form   url.Values
+		status int
+		code   string
+	}{
+		{"unknown key", url.Values{"key": {"00000000-0000-4000-8000-000000000000"},
+			"password": {"x"}}, 404, "not_found"},
+		{"invalid key", url.Values{"key": {"nope"}, "password": {"x"}}, 400,
+			"invalid_key"},
+		{"no key or hash", url.Values{"password": {"x"}}, 400, "bad_request"},
+		{"key and hash", url.Values{"key": {"42"}, "hash": {"$x"},
+			"password": {"x"}}, 400, "bad_request"},
+		{"no password", url.Values{"key": {"42"}}, 400, "bad_request"},
+		{"bad hash", url.Values{"hash": {"$nosuch$x$y"}, "password": {"x"}},
+			400, "bad_hash_format"},
+		{"hash beyond Verify's limits", url.Values{
+			"hash":     {"$argon2id$v=19$m=1048576,t=64,p=4$MHeMZVm6LBOCSvMkorTavA$QyUC5xof+RizqCCsOTTb6CX2SlJunEJEJkAW4UUYfgs"},
+			"password": {"x"}}, 400, "bad_hash_format"},
+		{"hash beyond the policy", url.Values{
+			"hash":     {"$2a$14$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+			"password": {"x"}}, 400, "not_allowed"},
+	} {
+		status, body := do(t, ts, "POST", "/verify", tc.form, asJSON...)
+		expectError(t, "POST /verify "+tc.what, status, body, tc.status,
+			tc.code)
+	}
+	status, body := do(t, ts, "GET", "/verify", nil, asJSON...)
+	expectError(t, "GET /verify", status, body, 405, "method_not_allowed")
+}
+
+func TestStats(t *testing.T) {
+	_, ts := newServer(t)
+	hashPassword(t, ts, "angryMonkey")
+	hashPassword(t, ts, "angryMonkey2")
+	status, body := do(t, ts, "GET", "/stats", nil)
+	var st hashserver.Stats
+	decode(t, body, &st)
+	if status != http.StatusOK || st.Total != 2 || st.Average <= 0 ||
+		st.Stored != 0 || st.Requests["/hash"].Count != 2 {
+		t.Errorf("GET /stats: %d %q", status, body)
+	}
+	status, body = do(t, ts, "GET", "/stats?window=5m", nil)
+	st = hashserver.Stats{}
+	decode(t, body, &st)
+	if status != http.StatusOK || st.Window != "5m" || st.Total != 2 {
+		t.Errorf("GET /stats?window=5m: %d %q", status, body)
+	}
+	status, body = do(t, ts, "GET", "/stats?window=2h", nil, asJSON...)
+	expectError(t, "GET /stats?window=2h", status, body, 400, "bad_request")
+	status, body = do(t, ts, "POST", "/stats", nil, asJSON...)
+	expectError(t, "POST /stats", status, body, 405, "method_not_allowed")
+}
+
+func TestStatsStored(t *testing.T) {
+	// the keys of earlier runs count in the total, not in the average
+	store := hashstore.NewMemoryStore()
+	for _, key := range []string{"1", "2", "3"} {
+		if err := store.Put(key, passhash.HashifyPW(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hs, ts := newServer(t, hashserver.WithStore(store))
+	hashPassword(t, ts, "angryMonkey")
+	st, err := hs.Stats("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Total != 4 || st.Stored != 3 || st.Average <= 0 ||
+		st.Average > int(10*time.Second/time.Microsecond) {
+		t.Errorf("stats total %d, stored %d, average %dµs", st.Total,
+			st.Stored, st.Average)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	_, ts := newServer(t)
+	hashPassword(t, ts, "angryMonkey")
+	status, body := do(t, ts, "GET", "/metrics", nil)
+	for _, want := range []string{
+		`hashpw_http_requests_total{endpoint="/hash",code="200"} 1`,
+		"hashpw_http_requests_in_flight ",
+		`hashpw_hash_duration_seconds_count{algorithm="pbkdf2-sha512"} 1`,
+	} {
+		if status != http.StatusOK || !strings.Contains(body, want) {
+			t.Errorf("GET /metrics: %d, no %q in\n%s", status, want, body)
+		}
+	}
+	status, body = do(t, ts, "POST", "/metrics", nil, asJSON...)
+	expectError(t, "POST /metrics", status, body, 405, "method_not_allowed")
+}
+
+func TestShutdown(t *testing.T) {
+	hs, ts := newServer(t)
+	key := hashPassword(t, ts, "angryMonkey")
+	status, body := do(t, ts, "GET", "/shutdown", nil, asJSON...)
+	expectError(t, "GET /shutdown", status, body, 405, "method_not_allowed")
+	select {
+	case <-hs.ShutdownRequested():
+		t.Fatal("shutdown requested by GET")
+	default:
+	}
+
+	status, body = do(t, ts, "PUT", "/shutdown", nil, asJSON...)
+	if status != http.StatusAccepted ||
+		!strings.Contains(body, `"status":"shutting_down"`) {
+		t.Fatalf("PUT /shutdown: %d %q", status, body)
+	}
+	select {
+	case <-hs.ShutdownRequested():
+	case <-time.After(time.Second):
+		t.Fatal("shutdown not requested")
+	}
+	status, body = do(t, ts, "POST", "/hash",
+		url.Values{"password": {"angryMonkey"}}, asJSON...)
+	expectError(t, "POST /hash after shutdown", status, body, 417,
+		"shutting_down")
+	// what is stored can still be read
+	if status, body := do(t, ts, "GET", "/hash/"+key, nil); status != http.StatusOK {
+		t.Errorf("GET /hash/{key} after shutdown: %d %q", status, body)
+	}
+	if status, _ := do(t, ts, "PUT", "/shutdown", nil); status != http.StatusAccepted {
+		t.Errorf("second PUT /shutdown: %d", status)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	_, ts := newServer(t, hashserver.WithAuthFile(authFile(t, creds)))
+	key := "00000000-0000-4000-8000-000000000000"
+	endpoints := []struct {
+		method, path string
+		form         url.Values
+		status       int // of the admin's request
+	}{
+		{"POST", "/hash", url.Values{"password": {"angryMonkey"}}, 200},
+		{"GET", "/hash/" + key, nil, 404},
+		{"GET", "/hash/" + key + "/status", nil, 404},
+		{"POST", "/verify", url.Values{"key": {key}, "password": {"x"}}, 404},
+		{"GET", "/stats", nil, 200},
+		{"GET", "/metrics", nil, 200},
+		{"PUT", "/shutdown", nil, 202},
+	}
+	for _, ep := range endpoints {
+		what := ep.method + " " + ep.path
+		status, body := do(t, ts, ep.method, ep.path, ep.form, asJSON...)
+		expectError(t, what+" without credentials", status, body, 401,
+			"unauthorized")
+		status, body = do(t, ts, ep.method, ep.path, ep.form,
+			"X-API-Key", opsToken)
+		if status != ep.status {
+			t.Errorf("%s as admin: %d %q, want %d", what, status, body,
+				ep.status)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// a token every 100 seconds, so none come back during the test
+	_, ts := newServer(t, hashserver.WithLimits(0.01, 2, 4))
+	key := hashPassword(t, ts, "angryMonkey")
+	verify := url.Values{"key": {key}, "password": {"angryMonkey"}}
+	if status, body := do(t, ts, "POST", "/verify", verify); status != http.StatusOK {
+		t.Fatalf("POST /verify: %d %q", status, body)
+	}
+	// the bucket of 2 is drained, for /hash and /verify alike
+	for _, req := range []struct {
+		path string
+		form url.Values
+	}{
+		{"/hash", url.Values{"password": {"angryMonkey"}}},
+		{"/verify", verify},
+	} {
+		resp, err := ts.Client().PostForm(ts.URL+req.path, req.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests ||
+			resp.Header.Get("Retry-After") == "" {
+			t.Errorf("POST %s: %s, Retry-After %q, want 429", req.path,
+				resp.Status, resp.Header.Get("Retry-After"))
+		}
+	}
+	// the other endpoints are not rate limited
+	for _, path := range []string{"/hash/" + key, "/hash/" + key + "/status",
+		"/stats", "/metrics"} {
+		if status, body := do(t, ts, "GET", path, nil); status != http.StatusOK {
+			t.Errorf("GET %s: %d %q", path, status, body)
+		}
+	}
+	var st hashserver.Stats
+	_, body := do(t, ts, "GET", "/stats", nil)
+	decode(t, body, &st)
+	if st.Limited != 2 {
+		t.Errorf("stats limited %d, want 2", st.Limited)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	alog, err := audit.Open(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { alog.Close() })
+	_, ts := newServer(t, hashserver.WithAuthFile(authFile(t, creds)),
+		hashserver.WithAuditLog(alog))
+
+	status, body := do(t, ts, "POST", "/hash",
+		url.Values{"password": {"angryMonkey"}}, "X-API-Key", signupToken)
+	if status != http.StatusOK {
+		t.Fatalf("POST /hash: %d %q", status, body)
+	}
+	key := strings.TrimSpace(body)
+	// GET /hash/{key}/status is not audited
+	for range 500 {
+		_, body = do(t, ts, "GET", "/hash/"+key+"/status", nil,
+			"X-API-Key", loginToken)
+		if strings.Contains(body, `"status":"done"`) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	do(t, ts, "GET", "/hash/"+key, nil, "X-API-Key", loginToken)
+	do(t, ts, "POST", "/verify", url.Values{"key": {key},
+		"password": {"angryMonkey"}}, "Authorization", "Bearer "+loginToken)
+	do(t, ts, "GET", "/stats", nil)
+	do(t, ts, "POST", "/hash", url.Values{"password": {"x"}},
+		"X-API-Key", loginToken)
+	do(t, ts, "PUT", "/shutdown", nil, "X-API-Key", opsToken)
+
+	chain, err := audit.Verify(path)
+	if err != nil || !chain.Anchored {
+		t.Fatalf("audit.Verify: %+v, %v", chain, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r audit.Record
+		decode(t, line, &r)
+		if r.Key != "" && r.Key != key {
+			t.Errorf("record %d of key %q, want %s", r.Seq, r.Key, key)
+		}
+		got = append(got, r.Event+" "+r.Actor+" "+r.Outcome)
+	}
+	want := []string{
+		"hash signup accepted",
+		"retrieve login found",
+		"verify login match",
+		"access_denied  unauthenticated",
+		"access_denied login forbidden_submitter",
+		"shutdown ops accepted",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("audit records\n%s\nwant\n%s", strings.Join(got, "\n"),
+			strings.Join(want, "\n"))
+	}
+}
+
+func TestJobExpired(t *testing.T) {
+	gh := newGateHasher(t)
+	_, ts := newServer(t, hashserver.WithHasher(gh),
+		hashserver.WithWorkers(1, 10),
+		hashserver.WithJobTimeout(50*time.Millisecond))
+	var keys []string
+	for _, pw := range []string{"angryMonkey", "angryMonkey2"} {
+		status, body := do(t, ts, "POST", "/hash", url.Values{"password": {pw}})
+		if status != http.StatusOK {
+			t.Fatalf("POST /hash: %d %q", status, body)
+		}
+		keys = append(keys, strings.TrimSpace(body))
+	}
+	// the first job holds the worker until the second has expired
+	time.Sleep(100 * time.Millisecond)
+	close(gh.gate)
+	waitHashed(t, ts, keys[0])
+	for range 500 {
+		_, body := do(t, ts, "GET", "/hash/"+keys[1]+"/status", nil)
+		if strings.Contains(body, `"status":"expired"`) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	status, body := do(t, ts, "GET", "/hash/"+keys[1], nil, asJSON...)
+	expectError(t, "GET /hash/{key} of the expired job", status, body, 410,
+		"job_expired")
+
+	// the expired job is a hash request, but its wait is no hash time
+	var st hashserver.Stats
+	_, body = do(t, ts, "GET", "/stats", nil)
+	decode(t, body, &st)
+	if st.Total != 2 || st.Latency.Count != 1 || st.Average <= 0 {
+		t.Errorf("GET /stats: %q, want a total of 2 averaging the one hashed",
+			body)
+	}
+	st = hashserver.Stats{}
+	_, body = do(t, ts, "GET", "/stats?window=1m", nil)
+	decode(t, body, &st)
+	if st.Total != 1 || st.Latency.Count != 1 {
+		t.Errorf("GET /stats?window=1m: %q, want a total of 1", body)
+	}
+	_, body = do(t, ts, "GET", "/metrics", nil)
+	want := `hashpw_hash_duration_seconds_count{algorithm="pbkdf2-sha512"} 1`
+	if !strings.Contains(body, want) {
+		t.Errorf("GET /metrics: no %q in\n%s", want, body)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Deferred hashing of POSTed passwords.  hashPostReq returns the key at
+// once and queues a hash job; a fixed pool of worker goroutines takes
+// jobs off the bounded queue, hashes and stores the passwords.  When the
+// queue is full new POSTs are turned away with 503 and a Retry-After
+// estimate.
+//
+// Each job's progress is kept as a jobStatus, moving from queued to
+// running to done, or failed if the password could not be hashed.  A job
+// left waiting in the queue longer than the job timeout is expired
+// instead of run.  Statuses of finished jobs are forgotten after a while;
+// a key whose status is gone but whose hash is in the store is done.
+//
+
+package hashserver
+
+import (
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// job states
+const (
+	statusQueued  = "queued"
+	statusRunning = "running"
+	statusDone    = "done"
+	statusFailed  = "failed"
+	statusExpired = "expired"
+)
+
+// jobStatus -- progress of the hash job for a key, as returned by
+// GET /hash/{key}/status
+type jobStatus struct {
+	Key      string     `json:"key"`
+	Status   string     `json:"status"`
+	Queued   *time.Time `json:"queued_at,omitempty"`
+	Started  *time.Time `json:"started_at,omitempty"`
+	Finished *time.Time `json:"finished_at,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// hashJob -- a password waiting to be hashed and stored under key
+type hashJob struct {
+	key       string
+	pw        string
+	hasher    passhash.Hasher // algorithm and cost chosen for this password
+	starttime time.Time       // when the POST request arrived
+	logger    *slog.Logger    // of the POST request, with its request ID
+}
+
+// jobQueue -- the hash jobs of a Server and the workers running them
+type jobQueue struct {
+	ch        chan hashJob   // bounded queue of jobs waiting for a worker
+	qlen      int            // jobs the queue holds
+	nworkers  int            // number of workers servicing the queue
+	workerWG  sync.WaitGroup // workers still running
+	timeout   time.Duration  // longest a job may wait in the queue, 0 for ever
+	statusTTL time.Duration  // how long statuses of finished jobs are kept
+
+	closemut sync.Mutex // mutex to safeguard sending on ch against closing it
+	closed   bool       // whether ch is closed
+
+	statmut sync.Mutex            // mutex to safeguard the job statuses
+	stats   map[string]*jobStatus // status of recent jobs, by key
+}
+
+// startWorkers -- make the job queue and start the workers servicing it
+func (s *Server) startWorkers() {
+	q := &s.jobs
+	q.ch = make(chan hashJob, q.qlen)
+	q.workerWG.Add(q.nworkers)
+	for i := 0; i < q.nworkers; i++ {
+		go s.hashWorker()
+	}
+	go s.forgetStatuses()
+}
+
+// close -- close the queue, so the workers stop once it is empty.  False
+// if it was already closed.
+func (q *jobQueue) close() bool {
+	q.closemut.Lock()
+	defer q.closemut.Unlock()
+	if q.closed {
+		return false
+	}
+	q.closed = true
+	close(q.ch)
+	return true
+}
+
+// enqueueJob -- queue pw to be hashed with h and stored under key, logging
+// to lg.  Returns false, without queueing, if the queue is full or closed.
+func (s *Server) enqueueJob(lg *slog.Logger, key string, pw string,
+	h passhash.Hasher, starttime time.Time) bool {
+	q := &s.jobs
+	q.setStatus(key, func(js *jobStatus) {
+		js.Status = statusQueued
+		js.Queued = &starttime
+	})
+	q.closemut.Lock()
+	defer q.closemut.Unlock()
+	if !q.closed {
+		select {
+		case q.ch <- hashJob{key: key, pw: pw, hasher: h,
+			starttime: starttime, logger: lg}:
+			return true
+		default:
+		}
+	}
+	q.statmut.Lock()
+	delete(q.stats, key)
+	q.statmut.Unlock()
+	return false
+}
+
+// setStatus -- apply update to the status of key's job
+func (q *jobQueue) setStatus(key string, update func(js *jobStatus)) {
+	q.statmut.Lock()
+	defer q.statmut.Unlock()
+	js, ok := q.stats[key]
+	if !ok {
+		js = &jobStatus{Key: key}
+		q.stats[key] = js
+	}
+	update(js)
+}
+
+// statusOf -- a copy of the status of key's job.  ok is false if no job
+// for key is known, either queued, running or recently finished.
+func (s *Server) statusOf(key string) (js jobStatus, ok bool) {
+	q := &s.jobs
+	q.statmut.Lock()
+	defer q.statmut.Unlock()
+	if p, found := q.stats[key]; found {
+		return *p, true
+	}
+	return jobStatus{}, false
+}
+
+// forgetStatuses -- periodically drop statuses of jobs finished more than
+// statusTTL ago, so the map does not grow without bound, until Shutdown
+func (s *Server) forgetStatuses() {
+	q := &s.jobs
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.doneCh:
+			return
+		case <-tick.C:
+		}
+		cutoff := time.Now().Add(-q.statusTTL)
+		q.statmut.Lock()
+		for key, js := range q.stats {
+			if js.Finished != nil && js.Finished.Before(cutoff) {
+				delete(q.stats, key)
+			}
+		}
+		q.statmut.Unlock()
+	}
+}
+
+// retryAfter -- seconds a turned away client should wait before trying
+// again: roughly the time for the workers to get through the queue
+func (s *Server) retryAfter() int {
+	secs := 1
+	if _, avg := s.st.average(); avg > 0 {
+		secs += int((avg * time.Duration(len(s.jobs.ch)) /
+			time.Duration(s.jobs.nworkers)).Seconds())
+	}
+	return secs
+}
+
+// hashWorker -- hash and store queued passwords, until the queue is
+// closed and empty
+func (s *Server) hashWorker() {
+	defer s.jobs.workerWG.Done()
+	for job := range s.jobs.ch {
+		s.runJob(job)
+	}
+}
+
+// runJob -- generate and store the hashed password of a job
+func (s *Server) runJob(job hashJob) {
+	q := &s.jobs
+	started := time.Now()
+	if q.timeout > 0 && started.Sub(job.starttime) > q.timeout {
+		job.logger.Warn("hash job expired in queue", "key", job.key)
+		q.setStatus(job.key, func(js *jobStatus) {
+			js.Status = statusExpired
+			js.Finished = &started
+		})
+		// not hashed, so not timed: only its wait in the queue is known
+		job.logger.Debug("hash job done", "outstanding", s.st.jobDone())
+		return
+	}
+	q.setStatus(job.key, func(js *jobStatus) {
+		js.Status = statusRunning
+		js.Started = &started
+	})
+	// as per instruction, sleep 5 seconds, generate and store the hashed pw
+	// time.Sleep(5000 * time.Millisecond)
+	s.acquireHashSlot()
+	pwhash, err := job.hasher.Hash(job.pw)
+	s.releaseHashSlot()
+	if err == nil {
+		err = s.store.Put(job.key, pwhash)
+	}
+	finished := time.Now()
+	if err != nil {
+		job.logger.Error("unable to hash and store password",
+			"key", job.key, "err", err)
+		q.setStatus(job.key, func(js *jobStatus) {
+			js.Status = statusFailed
+			js.Finished = &finished
+			js.Error = "unable to hash and store password"
+		})
+	} else {
+		job.logger.Debug("password hashed", "key", job.key,
+			"algorithm", job.hasher.Name())
+		q.setStatus(job.key, func(js *jobStatus) {
+			js.Status = statusDone
+			js.Finished = &finished
+		})
+	}
+	took := finished.Sub(job.starttime)
+	s.st.jobTimed(took)
+	s.met.observeHash(job.hasher.Name(), took)
+	job.logger.Debug("hash job done", "outstanding", s.st.jobDone())
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Request bodies of POST /hash.  Besides the form encoded
+// "password=<string>" a client may send "Content-Type: application/json"
+// and choose the algorithm and cost parameters for its password:
+//    {"password": "angryMonkey", "algorithm": "scrypt",
+//     "params": {"ln": 16, "r": 8, "p": 1}}
+// "algorithm" defaults to the server's, "params" to the algorithm's
+// defaults.  Parameters are named as in the encoded hashes, see
+// pwhashutil.NewHasherParams.  Algorithms and costs beyond the server's
+// policy (see WithPolicy) are refused with 400 and code "not_allowed".
+//
+
+package hashserver
+
+import (
+	"encoding/json"
+	"errors"
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+)
+
+// maxHashRequest -- largest JSON body accepted by POST /hash
+const maxHashRequest = 64 << 10
+
+// hashRequest -- JSON body of POST /hash
+type hashRequest struct {
+	Password  string         `json:"password"`
+	Algorithm string         `json:"algorithm,omitempty"`
+	Params    map[string]int `json:"params,omitempty"`
+}
+
+// LogValue -- hr as logged, leaving out the password
+func (hr hashRequest) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("algorithm", hr.Algorithm),
+		slog.Any("params", hr.Params))
+}
+
+// readHashRequest -- the password of a POST /hash request and the hasher
+// to hash it with.  On failure the error response has been written and
+// ok is false.
+func (s *Server) readHashRequest(rw http.ResponseWriter,
+	req *http.Request) (pw string, h passhash.Hasher, ok bool) {
+	mediatype, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediatype == "application/json" {
+		return s.readHashJSON(rw, req)
+	}
+	pw = req.Form.Get("password")
+	if len(pw) > 0 {
+		return pw, s.hasher, true
+	}
+	if mediatype != "" && mediatype != "application/x-www-form-urlencoded" {
+		svrconfig.RequestLogger(req).Warn("POST /hash with unsupported Content-Type",
+			"content_type", mediatype)
+		writeError(rw, req, http.StatusUnsupportedMediaType, codeBadMediaType,
+			"Content-Type must be application/x-www-form-urlencoded or application/json")
+		return "", nil, false
+	}
+	svrconfig.RequestLogger(req).Warn("no password in body of POST request")
+	writeError(rw, req, http.StatusBadRequest, codeBadRequest,
+		"expecting body of: \"password=<string>\"")
+	return "", nil, false
+}
+
+// readHashJSON -- readHashRequest of an application/json body
+func (s *Server) readHashJSON(rw http.ResponseWriter, req *http.Request) (string,
+	passhash.Hasher, bool) {
+	var hr hashRequest
+	dec := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxHashRequest))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&hr)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("trailing data after JSON object")
+	}
+	if err != nil {
+		svrconfig.RequestLogger(req).Warn("POST /hash with bad JSON body",
+			"err", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(rw, req, http.StatusRequestEntityTooLarge,
+				codeTooLarge, "JSON body too large")
+		} else {
+			writeError(rw, req, http.StatusBadRequest, codeBadRequest,
+				"malformed JSON body: "+err.Error())
+		}
+		return "", nil, false
+	}
+	if len(hr.Password) == 0 {
+		svrconfig.RequestLogger(req).Warn("no password in JSON body of POST request")
+		writeError(rw, req, http.StatusBadRequest, codeBadRequest,
+			"expecting body of: {\"password\": \"<string>\"}")
+		return "", nil, false
+	}
+	if hr.Algorithm == "" && len(hr.Params) == 0 {
+		return hr.Password, s.hasher, true
+	}
+	if hr.Algorithm == "" {
+		hr.Algorithm = s.hasher.Name()
+	}
+	h, err := passhash.NewHasherParams(hr.Algorithm, hr.Params)
+	if err == nil {
+		err = s.policy.Check(h)
+	}
+	if err != nil {
+		svrconfig.RequestLogger(req).Warn("POST /hash with bad algorithm or parameters",
+			"err", err)
+		code := codeBadParams
+		switch {
+		case errors.Is(err, passhash.ErrUnknownAlgorithm):
+			code = codeBadAlgorithm
+		case errors.Is(err, passhash.ErrPolicy):
+			code = codeNotAllowed
+		}
+		writeError(rw, req, http.StatusBadRequest, code, err.Error())
+		return "", nil, false
+	}
+	return hr.Password, h, true
+}
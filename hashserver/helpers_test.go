@@ -0,0 +1,225 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Helpers of the hashserver tests: making a Server to test under
+// httptest, making requests of it and checking the answers.
+//
+
+package hashserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevewahl/GoTest/hashserver"
+	"github.com/stevewahl/GoTest/hashstore"
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+)
+
+// tokens of the clients of creds
+const (
+	signupToken = "signup-6f1c0b2a9e"
+	loginToken  = "login-0a7d3c5e41"
+	opsToken    = "ops-5b8e2f7a0c13"
+)
+
+// creds -- an auth file of a client of each role
+const creds = `[
+	{"name": "signup", "token": "` + signupToken + `", "roles": ["submitter"]},
+	{"name": "login", "token": "` + loginToken + `", "roles": ["reader"]},
+	{"name": "ops", "token": "` + opsToken + `", "roles": ["admin"]}]`
+
+// authFile -- the path of an auth file holding data
+func authFile(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// gateHasher -- a fast Hasher whose Hash waits for gate to be closed, to
+// keep the hash workers busy
+type gateHasher struct {
+	passhash.Hasher
+	gate chan struct{}
+}
+
+// newGateHasher -- a gateHasher with its gate shut
+func newGateHasher(t *testing.T) gateHasher {
+	t.Helper()
+	h, err := passhash.NewHasherParams(passhash.PBKDF2SHA512,
+		map[string]int{"i": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gateHasher{Hasher: h, gate: make(chan struct{})}
+}
+
+// Hash -- the hash of clearpw, once the gate is open
+func (g gateHasher) Hash(clearpw string) (string, error) {
+	<-g.gate
+	return g.Hasher.Hash(clearpw)
+}
+
+// newServer -- a Server with a fast hasher, a quiet logger and opts,
+// served by an httptest.Server until the test ends
+func newServer(t *testing.T, opts ...hashserver.Option) (*hashserver.Server,
+	*httptest.Server) {
+	t.Helper()
+	h, err := passhash.NewHasherParams(passhash.PBKDF2SHA512,
+		map[string]int{"i": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]hashserver.Option{hashserver.WithHasher(h),
+		hashserver.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))},
+		opts...)
+	hs, err := hashserver.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(hs)
+	t.Cleanup(func() {
+		ts.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := hs.Shutdown(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	return hs, ts
+}
+
+// do -- make a request of ts, form encoded if form is not nil, with
+// header name and value pairs, returning the status and body.  Safe to
+// call from any goroutine: failures are reported with t.Error.
+func do(t *testing.T, ts *httptest.Server, method, path string,
+	form url.Values, header ...string) (int, string) {
+	t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, ts.URL+path, body)
+	if err != nil {
+		t.Error(err)
+		return 0, ""
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0, ""
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+// asJSON -- header asking for a JSON answer
+var asJSON = []string{"Accept", "application/json"}
+
+// postBody -- POST body of Content-Type ctype to path of ts, asking for a
+// JSON answer, returning the status and body
+func postBody(t *testing.T, ts *httptest.Server, path, ctype,
+	body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest("POST", ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", ctype)
+	req.Header.Set("Accept", "application/json")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+// decode -- body as JSON into v
+func decode(t *testing.T, body string, v any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		t.Fatalf("%q: %v", body, err)
+	}
+}
+
+// expectError -- check a JSON error answer is of status and code
+func expectError(t *testing.T, what string, status int, body string,
+	wantStatus int, wantCode string) {
+	t.Helper()
+	var e struct {
+		Error  string `json:"error"`
+		Code   string `json:"code"`
+		Status int    `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		t.Errorf("%s: %d %q: %v", what, status, body, err)
+		return
+	}
+	if status != wantStatus || e.Status != wantStatus || e.Code != wantCode {
+		t.Errorf("%s: %d %q, want %d %s", what, status, body, wantStatus,
+			wantCode)
+	}
+}
+
+// hashPassword -- POST pw to /hash of ts, returning its key once hashed
+func hashPassword(t *testing.T, ts *httptest.Server, pw string) string {
+	t.Helper()
+	status, body := do(t, ts, "POST", "/hash", url.Values{"password": {pw}})
+	if status != http.StatusOK {
+		t.Fatalf("POST /hash: %d %q", status, body)
+	}
+	key := strings.TrimSpace(body)
+	if !hashstore.ValidKey(key) {
+		t.Fatalf("POST /hash: bad key %q", key)
+	}
+	waitHashed(t, ts, key)
+	return key
+}
+
+// waitHashed -- wait for the password of key to be hashed
+func waitHashed(t *testing.T, ts *httptest.Server, key string) {
+	t.Helper()
+	for range 500 {
+		status, body := do(t, ts, "GET", "/hash/"+key, nil)
+		switch status {
+		case http.StatusOK:
+			return
+		case http.StatusAccepted:
+			time.Sleep(10 * time.Millisecond)
+		default:
+			t.Fatalf("GET /hash/%s: %d %q", key, status, body)
+		}
+	}
+	t.Fatalf("password of %s not hashed", key)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// GET /metrics -- the figures of stats.go in the Prometheus text
+// exposition format, for scraping:
+//    $ curl http://localhost:8088/metrics
+//    # HELP hashpw_http_requests_total HTTP requests served, by endpoint and status code.
+//    # TYPE hashpw_http_requests_total counter
+//    hashpw_http_requests_total{endpoint="/hash",code="200"} 3
+//    ...
+//
+
+package hashserver
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// labelEscaper -- escapes a Prometheus label value
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsGetReq -- GET response handler exposing the server's metrics
+func (s *Server) metricsGetReq(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		writeError(rw, req, http.StatusMethodNotAllowed, codeBadMethod,
+			"ERROR in request to /metrics. Must be GET method")
+		svrconfig.RequestLogger(req).Warn("non GET method given to /metrics request",
+			"method", req.Method)
+		return
+	}
+	outstanding := s.st.outstanding()
+	stored := s.store.Len()
+	m := &s.met
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w := bufio.NewWriter(rw)
+	defer w.Flush()
+	header := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	m.mu.Lock()
+	header("hashpw_http_requests_total", "counter",
+		"HTTP requests served, by endpoint and status code.")
+	for _, ep := range sortedKeys(m.sinceUp.statuses) {
+		codes := make([]int, 0, len(m.sinceUp.statuses[ep]))
+		for code := range m.sinceUp.statuses[ep] {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "hashpw_http_requests_total{endpoint=\"%s\",code=\"%d\"} %d\n",
+				labelEscaper.Replace(ep), code, m.sinceUp.statuses[ep][code])
+		}
+	}
+	header("hashpw_http_requests_in_flight", "gauge",
+		"HTTP requests being served.")
+	fmt.Fprintf(w, "hashpw_http_requests_in_flight %d\n", m.inFlight)
+	header("hashpw_hash_duration_seconds", "histogram",
+		"Time from POST /hash to the password being hashed and stored, by algorithm.")
+	for _, alg := range sortedKeys(m.byAlgorithm) {
+		h := m.byAlgorithm[alg]
+		alg = labelEscaper.Replace(alg)
+		cumulative := 0
+		for i, bound := range latencyBounds {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "hashpw_hash_duration_seconds_bucket{algorithm=\"%s\",le=\"%s\"} %d\n",
+				alg, strconv.FormatFloat(bound.Seconds(), 'g', -1, 64),
+				cumulative)
+		}
+		fmt.Fprintf(w, "hashpw_hash_duration_seconds_bucket{algorithm=\"%s\",le=\"+Inf\"} %d\n",
+			alg, h.count)
+		fmt.Fprintf(w, "hashpw_hash_duration_seconds_sum{algorithm=\"%s\"} %s\n",
+			alg, strconv.FormatFloat(h.sum.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(w, "hashpw_hash_duration_seconds_count{algorithm=\"%s\"} %d\n",
+			alg, h.count)
+	}
+	m.mu.Unlock()
+
+	header("hashpw_hash_jobs_outstanding", "gauge",
+		"Accepted passwords not yet hashed and stored.")
+	fmt.Fprintf(w, "hashpw_hash_jobs_outstanding %d\n", outstanding)
+	header("hashpw_hash_queue_depth", "gauge",
+		"Passwords waiting for a hash worker.")
+	fmt.Fprintf(w, "hashpw_hash_queue_depth %d\n", len(s.jobs.ch))
+	header("hashpw_hash_queue_capacity", "gauge",
+		"Passwords the hash job queue holds.")
+	fmt.Fprintf(w, "hashpw_hash_queue_capacity %d\n", cap(s.jobs.ch))
+	header("hashpw_hash_workers", "gauge", "Hash worker goroutines.")
+	fmt.Fprintf(w, "hashpw_hash_workers %d\n", s.jobs.nworkers)
+	header("hashpw_store_hashes", "gauge", "Hashed passwords in the store.")
+	fmt.Fprintf(w, "hashpw_store_hashes %d\n", stored)
+}
+
+// sortedKeys -- the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Stress test of a Server, every endpoint at once and a shutdown in the
+// middle, for running under the race detector:
+//
+//    $ go test -race -run Race ./hashserver
+//
+
+package hashserver_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stevewahl/GoTest/hashserver"
+)
+
+func TestRace(t *testing.T) {
+	const (
+		clients = 8
+		rounds  = 25
+	)
+	hs, ts := newServer(t, hashserver.WithWorkers(4, clients*rounds))
+	var (
+		wg       sync.WaitGroup
+		accepted atomic.Int64
+		keys     sync.Map // key -> password
+	)
+	// expect -- report an answer of none of the statuses want
+	expect := func(what string, status int, body string, want ...int) {
+		if !slices.Contains(want, status) {
+			t.Errorf("%s: unexpected %d %q", what, status, body)
+		}
+	}
+	for c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range rounds {
+				if c == 0 && r == rounds/2 {
+					status, body := do(t, ts, "PUT", "/shutdown", nil)
+					expect("PUT /shutdown", status, body, http.StatusAccepted)
+				}
+				pw := fmt.Sprintf("pw-%d-%d", c, r)
+				status, body := do(t, ts, "POST", "/hash",
+					url.Values{"password": {pw}})
+				expect("POST /hash", status, body, http.StatusOK,
+					http.StatusExpectationFailed)
+				if status == http.StatusOK {
+					key := strings.TrimSpace(body)
+					keys.Store(key, pw)
+					accepted.Add(1)
+					status, body = do(t, ts, "GET", "/hash/"+key, nil)
+					expect("GET /hash/{key}", status, body, http.StatusOK,
+						http.StatusAccepted)
+					status, body = do(t, ts, "GET", "/hash/"+key+"/status", nil)
+					expect("GET /hash/{key}/status", status, body, http.StatusOK)
+					status, body = do(t, ts, "POST", "/verify",
+						url.Values{"key": {key}, "password": {pw}})
+					expect("POST /verify", status, body, http.StatusOK,
+						http.StatusNotFound)
+					if status == http.StatusOK && body != "match\n" {
+						t.Errorf("POST /verify of %s: %q", key, body)
+					}
+				}
+				status, body = do(t, ts, "GET", "/stats", nil)
+				expect("GET /stats", status, body, http.StatusOK)
+				status, body = do(t, ts, "GET", "/metrics", nil)
+				expect("GET /metrics", status, body, http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-hs.ShutdownRequested():
+	default:
+		t.Fatal("shutdown not requested")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := hs.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// every accepted password was hashed and stored
+	keys.Range(func(key, pw any) bool {
+		status, body := do(t, ts, "POST", "/verify",
+			url.Values{"key": {key.(string)}, "password": {pw.(string)}})
+		if status != http.StatusOK || body != "match\n" {
+			t.Errorf("POST /verify of %s after shutdown: %d %q", key,
+				status, body)
+		}
+		return true
+	})
+	st, err := hs.Stats("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(st.Total) != accepted.Load() || st.Average <= 0 {
+		t.Errorf("stats total %d average %d, want total %d and an average",
+			st.Total, st.Average, accepted.Load())
+	}
+}
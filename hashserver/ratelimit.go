@@ -0,0 +1,141 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Rate limiting and the cap on concurrent hashing.  Given a rate by
+// WithLimits (-ratelimit) each client may make that many POST /hash and
+// /verify requests a second, in bursts of up to the burst (-burst),
+// before getting 429 and a Retry-After header.  Clients are told apart by
+// their credential (see auth.go), else their TLS client identity, else
+// their IP address.
+//
+// However many requests are let in, no more than maxHashing (-maxhashing)
+// passwords are hashed or verified at once: hash workers wait their turn,
+// /verify requests get 429.
+//
+
+package hashserver
+
+import (
+	"github.com/stevewahl/GoTest/svrconfig"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucket -- token bucket of one client
+type bucket struct {
+	tokens float64   // requests the client may make right now
+	last   time.Time // when tokens was last brought up to date
+}
+
+// limiter -- the rate limits and the cap on hashing of a Server
+type limiter struct {
+	mu         sync.Mutex // mutex to safeguard the rate limiter
+	rate       float64    // requests per second per client, 0 for no limit
+	burst      int        // most requests a client may make at once
+	buckets    map[string]*bucket
+	lastSweep  time.Time // when idle buckets were last dropped
+	limited    int       // requests turned away by the rate limiter
+	maxHashing int
+	slots      chan struct{} // semaphore of the hashing going on
+}
+
+// clientOf -- the name req's client is rate limited under
+func clientOf(req *http.Request) string {
+	if c := credentialOf(req); c != nil {
+		return "cred:" + c.Name
+	}
+	if client := svrconfig.ClientIdentity(req); client != "" {
+		return "tls:" + client
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// allow -- take a token from client's bucket at time now.  If there is
+// none, returns false and how long until there will be.
+func (l *limiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	full := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) > time.Minute {
+		// forget clients whose buckets have filled up again
+		for c, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, c)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.burst),
+		b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	l.limited++
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// limited -- handler wrapping h, turning away clients over the rate limit
+func (s *Server) limited(h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if s.lim.rate <= 0 {
+			h(rw, req)
+			return
+		}
+		client := clientOf(req)
+		if ok, wait := s.lim.allow(client, time.Now()); !ok {
+			svrconfig.RequestLogger(req).Warn("rate limit exceeded",
+				"client", client)
+			secs := int(math.Ceil(wait.Seconds()))
+			rw.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeError(rw, req, http.StatusTooManyRequests, codeRateLimited,
+				"Too many requests, try again later.")
+			return
+		}
+		h(rw, req)
+	}
+}
+
+// acquireHashSlot -- wait for a turn to hash
+func (s *Server) acquireHashSlot() {
+	s.lim.slots <- struct{}{}
+}
+
+// tryHashSlot -- take a turn to hash if one is free
+func (s *Server) tryHashSlot() bool {
+	select {
+	case s.lim.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseHashSlot -- give back a turn taken by acquire- or tryHashSlot
+func (s *Server) releaseHashSlot() {
+	<-s.lim.slots
+}
+
+// stats -- the figures of the limits for /stats
+func (l *limiter) stats() (limited, hashing, hashingCap int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limited, len(l.slots), cap(l.slots)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of rate limiting and of the cap on concurrent hashing.
+//
+
+package hashserver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevewahl/GoTest/hashserver"
+)
+
+// postHash -- POST a password to /hash of ts as the client of token, ""
+// for none, returning the response with its body closed
+func postHash(t *testing.T, ts *httptest.Server, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("POST", ts.URL+"/hash",
+		strings.NewReader("password=angryMonkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		req.Header.Set("X-API-Key", token)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRateLimitDrained(t *testing.T) {
+	// a token every 100 seconds, so none come back during the test
+	_, ts := newServer(t, hashserver.WithLimits(0.01, 3, 4))
+	for i := range 3 {
+		if resp := postHash(t, ts, ""); resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /hash %d of the burst: %s", i+1, resp.Status)
+		}
+	}
+	resp := postHash(t, ts, "")
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if resp.StatusCode != http.StatusTooManyRequests || err != nil ||
+		secs < 90 || secs > 100 {
+		t.Errorf("POST /hash past the burst: %s, Retry-After %q, want 429 "+
+			"and 100 seconds", resp.Status, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestRateLimitPerClient(t *testing.T) {
+	_, ts := newServer(t, hashserver.WithAuthFile(authFile(t, creds)),
+		hashserver.WithLimits(0.01, 1, 4))
+	if resp := postHash(t, ts, signupToken); resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /hash by signup: %s", resp.Status)
+	}
+	if resp := postHash(t, ts, signupToken); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second POST /hash by signup: %s, want 429", resp.Status)
+	}
+	// the clients have buckets of their own, though at the same address
+	if resp := postHash(t, ts, opsToken); resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /hash by ops: %s", resp.Status)
+	}
+}
+
+func TestRateLimitRefill(t *testing.T) {
+	// a token every 50 milliseconds
+	_, ts := newServer(t, hashserver.WithLimits(20, 2, 4))
+	for range 2 {
+		postHash(t, ts, "")
+	}
+	if resp := postHash(t, ts, ""); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("POST /hash past the burst: %s, want 429", resp.Status)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if resp := postHash(t, ts, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /hash a token later: %s", resp.Status)
+	}
+	if resp := postHash(t, ts, ""); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("POST /hash after the one token: %s, want 429", resp.Status)
+	}
+	// never more than the burst, however long idle
+	time.Sleep(200 * time.Millisecond)
+	for i, want := range []int{200, 200, 429} {
+		if resp := postHash(t, ts, ""); resp.StatusCode != want {
+			t.Errorf("POST /hash %d after idling: %s, want %d", i+1,
+				resp.Status, want)
+		}
+	}
+}
+
+func TestVerifyBusy(t *testing.T) {
+	gh := newGateHasher(t)
+	_, ts := newServer(t, hashserver.WithHasher(gh),
+		hashserver.WithLimits(0, 1, 1))
+	defer close(gh.gate)
+	// the one hashing slot is held by the worker hashing this password
+	if resp := postHash(t, ts, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /hash: %s", resp.Status)
+	}
+	for range 500 {
+		var st hashserver.Stats
+		_, body := do(t, ts, "GET", "/stats", nil)
+		decode(t, body, &st)
+		if st.Hashing == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, err := ts.Client().PostForm(ts.URL+"/verify", url.Values{
+		"hash":     {"$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+		"password": {"U*U"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests ||
+		resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("POST /verify with the slot busy: %s, Retry-After %q, "+
+			"want 429", resp.Status, resp.Header.Get("Retry-After"))
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Response bodies.  A client sending "Accept: application/json" gets
+// JSON bodies from every endpoint, errors included:
+//    {"error": "GET method missing or invalid Hashed Password key",
+//     "code": "invalid_key", "status": 400}
+// Anyone else gets the plain text lines curl users are used to.
+// /stats and /hash/{key}/status are always JSON.
+//
+
+package hashserver
+
+import (
+	"encoding/json"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// codes of JSON error bodies
+const (
+	codeBadRequest   = "bad_request"
+	codeInvalidKey   = "invalid_key"
+	codeNotFound     = "not_found"
+	codeBadMethod    = "method_not_allowed"
+	codeHashFailed   = "hash_failed"
+	codeJobExpired   = "job_expired"
+	codeBadHash      = "bad_hash_format"
+	codeBusy         = "busy"
+	codeShuttingDown = "shutting_down"
+	codeInternal     = "internal_error"
+	codeBadMediaType = "unsupported_media_type"
+	codeTooLarge     = "request_too_large"
+	codeBadAlgorithm = "unknown_algorithm"
+	codeBadParams    = "bad_params"
+	codeNotAllowed   = "not_allowed"
+	codeUnauthorized = "unauthorized"
+	codeForbidden    = "forbidden"
+	codeRateLimited  = "rate_limited"
+)
+
+// errorBody -- JSON error response
+type errorBody struct {
+	Error  string `json:"error"`
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+}
+
+// hashBody -- JSON response of POST /hash and GET /hash/{key}
+type hashBody struct {
+	Key       string `json:"key"`
+	Status    string `json:"status"`
+	Hash      string `json:"hash,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// verifyBody -- JSON response of POST /verify
+type verifyBody struct {
+	Match    bool   `json:"match"`
+	Key      string `json:"key,omitempty"`
+	Rehashed bool   `json:"rehashed,omitempty"`
+}
+
+// shutdownBody -- JSON response of PUT /shutdown
+type shutdownBody struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// wantsJSON -- true if the request's Accept header asks for JSON
+func wantsJSON(req *http.Request) bool {
+	for _, accept := range req.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediatype, params, err := mime.ParseMediaType(part)
+			if err == nil && mediatype == "application/json" &&
+				params["q"] != "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeJSON -- send v as a JSON body with the given status, in response
+// to req
+func writeJSON(rw http.ResponseWriter, req *http.Request, status int,
+	v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		svrconfig.RequestLogger(req).Error("unable to encode JSON response",
+			"err", err)
+		status = http.StatusInternalServerError
+		b, _ = json.Marshal(errorBody{"unable to encode response",
+			codeInternal, status})
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(append(b, '\n'))
+}
+
+// writeError -- http.Error, or for a JSON client an errorBody
+func writeError(rw http.ResponseWriter, req *http.Request, status int,
+	code, msg string) {
+	if wantsJSON(req) {
+		writeJSON(rw, req, status, errorBody{msg, code, status})
+		return
+	}
+	http.Error(rw, msg, status)
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Package hashserver is the http password hashing service of
+// httpHashPWsvr_no6 as an http.Handler, for embedding in other programs
+// and for testing with httptest.  Its endpoints are documented in
+// httpHashPWsvr_no6:
+//
+//    POST /hash, GET /hash/{key}, GET /hash/{key}/status, POST /verify,
+//    GET /stats, GET /metrics, PUT /shutdown
+//
+// A Server is made by New with options, each defaulting as the command
+// line flags of httpHashPWsvr_no6 do:
+//
+//    srv, err := hashserver.New(hashserver.WithStore(store),
+//        hashserver.WithHasher(hasher), hashserver.WithLimits(5, 20, 4))
+//    if err != nil { ... }
+//    hs := &http.Server{Handler: srv}
+//    go hs.Serve(ln)
+//    <-srv.ShutdownRequested()         // PUT /shutdown
+//    hs.Shutdown(ctx)
+//    srv.Shutdown(ctx)                 // run the queued hash jobs
+//    store.Close()
+//
+// The store, audit log and logger belong to the caller; Shutdown does not
+// close them.
+//
+
+package hashserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stevewahl/GoTest/audit"
+	"github.com/stevewahl/GoTest/hashstore"
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// Server -- the password hashing service, an http.Handler
+type Server struct {
+	store       hashstore.HashStore // hashed password store, retrieved by key
+	hasher      passhash.Hasher     // password hashing algorithm
+	policy      *passhash.Policy    // what clients may ask hashing with
+	keyScheme   string              // form of new keys, see hashstore/keys.go
+	logger      *slog.Logger
+	auditLog    *audit.Log   // nil for no auditing
+	credentials []credential // clients allowed in, nil if anyone is
+	handler     http.Handler
+
+	doneCh chan struct{} // closed by Shutdown, stopping housekeeping
+
+	st   state // see state.go
+	jobs jobQueue
+	met  metrics
+	lim  limiter
+}
+
+// Option -- a setting of a Server, given to New
+type Option func(*Server) error
+
+// WithStore -- keep the hashed passwords in st, rather than in memory
+func WithStore(st hashstore.HashStore) Option {
+	return func(s *Server) error {
+		s.store = st
+		return nil
+	}
+}
+
+// WithHasher -- hash passwords with h, rather than the default algorithm
+func WithHasher(h passhash.Hasher) Option {
+	return func(s *Server) error {
+		s.hasher = h
+		return nil
+	}
+}
+
+// WithPolicy -- let clients ask for only the algorithms and costs of p,
+// rather than those of passhash.DefaultPolicy of the server's hasher
+func WithPolicy(p passhash.Policy) Option {
+	return func(s *Server) error {
+		s.policy = &p
+		return nil
+	}
+}
+
+// WithKeyScheme -- hand out keys of scheme, see hashstore.NewKey
+func WithKeyScheme(scheme string) Option {
+	return func(s *Server) error {
+		if _, err := hashstore.NewKey(scheme); err != nil {
+			return err
+		}
+		s.keyScheme = scheme
+		return nil
+	}
+}
+
+// WithWorkers -- hash passwords with workers goroutines, fed by a queue
+// holding up to queue waiting passwords
+func WithWorkers(workers, queue int) Option {
+	return func(s *Server) error {
+		if workers < 1 || queue < 0 {
+			return errors.New("hashserver: need at least one worker and a queue of 0 or more")
+		}
+		s.jobs.nworkers, s.jobs.qlen = workers, queue
+		return nil
+	}
+}
+
+// WithJobTimeout -- expire hash jobs waiting longer than d for a worker,
+// 0 for never
+func WithJobTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		s.jobs.timeout = d
+		return nil
+	}
+}
+
+// WithStatusTTL -- keep the status of a finished hash job for d
+func WithStatusTTL(d time.Duration) Option {
+	return func(s *Server) error {
+		s.jobs.statusTTL = d
+		return nil
+	}
+}
+
+// WithLimits -- allow each client rate hash and verify requests a second,
+// 0 for no limit, in bursts of up to burst, and hash or verify no more
+// than maxHashing passwords at once.  See ratelimit.go.
+func WithLimits(rate float64, burst, maxHashing int) Option {
+	return func(s *Server) error {
+		if rate < 0 || burst < 1 || maxHashing < 1 {
+			return errors.New("hashserver: need a rate of 0 or more, and a burst and maxHashing of at least 1")
+		}
+		s.lim.rate, s.lim.burst, s.lim.maxHashing = rate, burst, maxHashing
+		return nil
+	}
+}
+
+// WithAuthFile -- let in only the clients of the credentials file at
+// path, see auth.go
+func WithAuthFile(path string) Option {
+	return func(s *Server) error {
+		creds, err := loadCredentials(path)
+		if err != nil {
+			return err
+		}
+		s.credentials = creds
+		return nil
+	}
+}
+
+// WithAuditLog -- record security relevant events in l, see audit.go
+func WithAuditLog(l *audit.Log) Option {
+	return func(s *Server) error {
+		s.auditLog = l
+		return nil
+	}
+}
+
+// WithLogger -- log to l rather than slog.Default().  Give its handler
+// svrconfig.RedactAttr as ReplaceAttr to keep secrets out of the log.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) error {
+		s.logger = l
+		return nil
+	}
+}
+
+// New -- a Server with opts, its hash workers started
+func New(opts ...Option) (*Server, error) {
+	hasher, err := passhash.NewHasher(passhash.DefaultAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{hasher: hasher, keyScheme: hashstore.DefaultKeyScheme,
+		logger: slog.Default(), doneCh: make(chan struct{})}
+	s.jobs = jobQueue{nworkers: runtime.NumCPU(), qlen: 100,
+		timeout: time.Minute, statusTTL: 10 * time.Minute,
+		stats: map[string]*jobStatus{}}
+	s.met = metrics{sinceUp: newCounters(), byAlgorithm: map[string]*histogram{}}
+	s.lim = limiter{burst: 10, maxHashing: runtime.NumCPU(),
+		buckets: map[string]*bucket{}}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.store == nil {
+		s.store = hashstore.NewMemoryStore()
+	}
+	if s.policy == nil {
+		p := passhash.DefaultPolicy(s.hasher)
+		s.policy = &p
+	}
+	// count on from the keys already in the store
+	s.st.init(s.store.Len())
+	s.lim.slots = make(chan struct{}, s.lim.maxHashing)
+	s.handler = svrconfig.LogRequests(s.logger, s.routes())
+	s.startWorkers()
+	return s, nil
+}
+
+// routes -- the endpoints of the service, with the roles they need
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hash", s.counted("/hash",
+		s.authorized(roleSubmitter, s.limited(s.hashPostReq))))
+	mux.HandleFunc("/hash/", s.counted("/hash/{key}",
+		s.authorized(roleReader, s.hashGetReq)))
+	mux.HandleFunc("/verify", s.counted("/verify",
+		s.authorized(roleReader, s.limited(s.verifyPostReq))))
+	mux.HandleFunc("/stats", s.counted("/stats",
+		s.authorized(roleAdmin, s.statsGetReq)))
+	mux.HandleFunc("/metrics", s.counted("/metrics",
+		s.authorized(roleAdmin, s.metricsGetReq)))
+	mux.HandleFunc("/shutdown", s.counted("/shutdown",
+		s.authorized(roleAdmin, s.shutPutReq)))
+	return mux
+}
+
+// ServeHTTP -- serve req, each request given an ID and logged
+func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.handler.ServeHTTP(rw, req)
+}
+
+// ShutdownRequested -- channel closed once a PUT to /shutdown, or
+// Shutdown, has stopped the server accepting new passwords
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.st.shutdownCh
+}
+
+// Shutdown -- stop accepting new passwords, and wait until ctx is done
+// for the hash jobs already queued to be run.  Call it once no more
+// requests are being served, after http.Server.Shutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.st.stopAccepting()
+	if !s.jobs.close() {
+		return nil
+	}
+	close(s.doneCh)
+	done := make(chan struct{})
+	go func() {
+		s.jobs.workerWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("hashserver: %d hash jobs abandoned: %w",
+			s.st.outstanding(), ctx.Err())
+	}
+}
+
+// Stats -- the statistics of GET /stats?window=window, "" for since start
+func (s *Server) Stats(window string) (Stats, error) {
+	if _, ok := windows[window]; !ok && window != "" {
+		return Stats{}, fmt.Errorf("hashserver: unknown stats window %q", window)
+	}
+	stats := s.met.collect(window)
+	stats.Queued, stats.QueueCap = len(s.jobs.ch), cap(s.jobs.ch)
+	stats.Limited, stats.Hashing, stats.HashCap = s.lim.stats()
+	if window == "" {
+		count, avg := s.st.average()
+		// using microsec rather than millisec as my averages < 1 millisecond
+		stats.Total, stats.Average = int(count), int(avg.Microseconds())
+		stats.Stored = int(s.st.stored)
+	}
+	return stats, nil
+}
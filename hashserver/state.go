@@ -0,0 +1,97 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// State shared by the handlers and hash workers of a Server: whether it
+// still takes new passwords, the hash jobs outstanding, and the totals
+// behind the /stats average.  All of it is atomic, so handlers never wait
+// on each other to read or count it, and the race detector has nothing
+// to find.  The rest of the shared state keeps its own locks: the stored
+// hashes in the store, job statuses in the jobQueue, counters in metrics
+// and buckets in the limiter.
+//
+
+package hashserver
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// state -- shutdown flag and job counts of a Server
+type state struct {
+	closing    atomic.Bool
+	shutdownCh chan struct{} // closed once closing is set
+	pending    atomic.Int64  // hash jobs accepted and not yet finished
+	stored     int64         // keys in the store at start, set by init
+	keys       atomic.Int64  // keys handed out since start
+	timed      atomic.Int64  // hash jobs run since start, not those expired
+	hashTime   atomic.Int64  // their total nanoseconds from POST to hash stored
+}
+
+// init -- set up the state of a Server whose store holds stored hashes
+func (st *state) init(stored int) {
+	st.shutdownCh = make(chan struct{})
+	st.stored = int64(stored)
+}
+
+// stopAccepting -- turn away new passwords from now on.  Only the first
+// call closes shutdownCh.
+func (st *state) stopAccepting() {
+	if st.closing.CompareAndSwap(false, true) {
+		close(st.shutdownCh)
+	}
+}
+
+// accepting -- whether new passwords are taken
+func (st *state) accepting() bool {
+	return !st.closing.Load()
+}
+
+// jobQueued -- count a hash job about to be queued
+func (st *state) jobQueued() {
+	st.pending.Add(1)
+}
+
+// jobTurnedAway -- uncount a job the queue had no room for
+func (st *state) jobTurnedAway() {
+	st.pending.Add(-1)
+}
+
+// jobAccepted -- count the key of a job the queue took
+func (st *state) jobAccepted() {
+	st.keys.Add(1)
+}
+
+// jobTimed -- count the time of a job hashed, took since its POST arrived
+func (st *state) jobTimed(took time.Duration) {
+	st.hashTime.Add(int64(took))
+	st.timed.Add(1)
+}
+
+// jobDone -- count a job finished, hashed or expired, returning the jobs
+// still outstanding
+func (st *state) jobDone() int64 {
+	return st.pending.Add(-1)
+}
+
+// outstanding -- hash jobs accepted and not yet finished
+func (st *state) outstanding() int64 {
+	return st.pending.Load()
+}
+
+// average -- keys stored before start and handed out since, and the
+// average time to hash the passwords of those hashed since start.  The
+// keys of earlier runs are counted, but their times are unknown.
+func (st *state) average() (int64, time.Duration) {
+	total := st.stored + st.keys.Load()
+	// hashTime and timed are not updated together; a job finishing in
+	// between only skews the average by a little
+	timed := st.timed.Load()
+	if timed == 0 {
+		return total, 0
+	}
+	return total, time.Duration(st.hashTime.Load() / timed)
+}
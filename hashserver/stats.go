@@ -0,0 +1,267 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Server statistics reported by GET /stats.  Every request is counted
+// by endpoint and response status, and the time from a POST /hash to
+// its password being hashed and stored goes into a latency histogram.
+// The figures are kept since start and, for the rolling windows, in
+// 15 second slots covering the last 15 minutes:
+//    $ curl http://localhost:8088/stats?window=5m
+//
+
+package hashserver
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// latencyBounds -- upper bounds of the hash latency histogram buckets,
+// beyond the last is one more bucket for anything slower
+var latencyBounds = [...]time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second,
+	30 * time.Second, time.Minute,
+}
+
+// histogram -- hash latencies counted by bucket
+type histogram struct {
+	counts [len(latencyBounds) + 1]int
+	count  int
+	sum    time.Duration
+	max    time.Duration
+}
+
+// observe -- count one latency of d
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// merge -- add the counts of o to h
+func (h *histogram) merge(o *histogram) {
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.count += o.count
+	h.sum += o.sum
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// quantile -- upper bound of the bucket holding the q quantile, no more
+// than the largest latency seen
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int(q*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank && i < len(latencyBounds) {
+			if latencyBounds[i] < h.max {
+				return latencyBounds[i]
+			}
+			break
+		}
+	}
+	return h.max
+}
+
+// counters -- request and hash latency figures of some period
+type counters struct {
+	requests map[string]int         // by endpoint
+	statuses map[string]map[int]int // by endpoint and status code
+	latency  histogram
+}
+
+func newCounters() *counters {
+	return &counters{requests: map[string]int{},
+		statuses: map[string]map[int]int{}}
+}
+
+// merge -- add the figures of o to c
+func (c *counters) merge(o *counters) {
+	for ep, n := range o.requests {
+		c.requests[ep] += n
+	}
+	for ep, byStatus := range o.statuses {
+		for status, n := range byStatus {
+			c.countStatus(ep, status, n)
+		}
+	}
+	c.latency.merge(&o.latency)
+}
+
+// countStatus -- add n responses of status at endpoint ep
+func (c *counters) countStatus(ep string, status, n int) {
+	if c.statuses[ep] == nil {
+		c.statuses[ep] = map[int]int{}
+	}
+	c.statuses[ep][status] += n
+}
+
+// rolling windows are made of slots of slotWidth, enough for the longest
+const (
+	slotWidth = 15 * time.Second
+	numSlots  = int(15 * time.Minute / slotWidth)
+)
+
+// windows -- the rolling windows GET /stats?window= can report
+var windows = map[string]time.Duration{
+	"1m": time.Minute, "5m": 5 * time.Minute, "15m": 15 * time.Minute,
+}
+
+// metrics -- the statistics of a Server
+type metrics struct {
+	mu          sync.Mutex            // mutex to safeguard the statistics below
+	inFlight    int                   // requests being served right now
+	sinceUp     *counters             // figures since start
+	byAlgorithm map[string]*histogram // hash latencies since start
+	slots       [numSlots]*counters   // figures of the last numSlots slots
+	slotStart   [numSlots]int64       // slot number each of slots holds
+}
+
+// slotAt -- the slot of time t, emptied if it held an older slot.
+// m.mu must be held.
+func (m *metrics) slotAt(t time.Time) *counters {
+	n := t.UnixNano() / int64(slotWidth)
+	i := int(n % int64(numSlots))
+	if m.slots[i] == nil || m.slotStart[i] != n {
+		m.slots[i] = newCounters()
+		m.slotStart[i] = n
+	}
+	return m.slots[i]
+}
+
+// observeHash -- count the latency of a finished hash job of algorithm
+func (m *metrics) observeHash(algorithm string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sinceUp.latency.observe(d)
+	m.slotAt(time.Now()).latency.observe(d)
+	if m.byAlgorithm[algorithm] == nil {
+		m.byAlgorithm[algorithm] = &histogram{}
+	}
+	m.byAlgorithm[algorithm].observe(d)
+}
+
+// statusRecorder -- ResponseWriter remembering the response status
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// counted -- handler wrapping h to count its requests under endpoint
+func (s *Server) counted(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	m := &s.met
+	return func(rw http.ResponseWriter, req *http.Request) {
+		m.mu.Lock()
+		m.inFlight++
+		m.mu.Unlock()
+		sr := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		h(sr, req)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.inFlight--
+		slot := m.slotAt(time.Now())
+		for _, c := range []*counters{m.sinceUp, slot} {
+			c.requests[endpoint]++
+			c.countStatus(endpoint, sr.status, 1)
+		}
+	}
+}
+
+// EndpointStats -- requests to one endpoint, in Stats
+type EndpointStats struct {
+	Count  int         `json:"count"`
+	Errors map[int]int `json:"errors,omitempty"` // by status code
+}
+
+// LatencyStats -- microseconds from POST /hash to the password being
+// stored, in Stats.  The percentiles are bucket bounds of a histogram.
+type LatencyStats struct {
+	Count int `json:"count"`
+	P50   int `json:"p50"`
+	P90   int `json:"p90"`
+	P99   int `json:"p99"`
+	Max   int `json:"max"`
+}
+
+// collect -- Stats of window, "" for since start.  Total and Average of
+// since start, and the queue and limit figures, are filled in by the
+// caller.
+func (m *metrics) collect(window string) Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.sinceUp
+	if window != "" {
+		c = newCounters()
+		now := time.Now().UnixNano() / int64(slotWidth)
+		oldest := now - int64(windows[window]/slotWidth)
+		for i, s := range m.slots {
+			if s != nil && m.slotStart[i] > oldest && m.slotStart[i] <= now {
+				c.merge(s)
+			}
+		}
+	}
+	st := Stats{Window: window, InFlight: m.inFlight,
+		Requests: map[string]EndpointStats{}, Errors: map[int]int{}}
+	for ep, n := range c.requests {
+		es := EndpointStats{Count: n}
+		for status, n := range c.statuses[ep] {
+			if status < 400 {
+				continue
+			}
+			if es.Errors == nil {
+				es.Errors = map[int]int{}
+			}
+			es.Errors[status] = n
+			st.Errors[status] += n
+		}
+		st.Requests[ep] = es
+	}
+	micros := func(d time.Duration) int { return int(d / time.Microsecond) }
+	st.Latency = LatencyStats{Count: c.latency.count,
+		P50: micros(c.latency.quantile(0.50)),
+		P90: micros(c.latency.quantile(0.90)),
+		P99: micros(c.latency.quantile(0.99)),
+		Max: micros(c.latency.max)}
+	if window != "" {
+		st.Total = c.latency.count
+		if c.latency.count > 0 {
+			st.Average = micros(c.latency.sum) / c.latency.count
+		}
+	}
+	return st
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// File backed HashStore.  Every Put appends a "<key>\t<hash>\n" record to
+// a log file and syncs it to disk; opening the file replays the log into
+// memory, the last record for a key winning.  A partial record left at
+// the end of the file by a crash is cut off.
+//
+
+package hashstore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FileStore -- HashStore persisted to an append-only log file
+type FileStore struct {
+	*MemoryStore
+	fmut sync.Mutex // serializes appends to the log file
+	file *os.File
+}
+
+// OpenFileStore -- open, creating if need be, the log file at path
+func OpenFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FileStore{MemoryStore: NewMemoryStore(), file: f}
+	if err := fs.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// replay -- load every complete record of the log file into memory
+func (fs *FileStore) replay() error {
+	rd := bufio.NewReader(fs.file)
+	lineno := 0
+	var end int64 // offset just past the last whole record
+	for {
+		line, err := rd.ReadString('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// partial record from an interrupted write; cut it off,
+				// as once terminated by the next append it would pass
+				// for a whole one
+				slog.Warn("hashstore: ignoring partial last record",
+					"file", fs.file.Name())
+				return fs.file.Truncate(end)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		end += int64(len(line))
+		lineno++
+		key, hash, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "\t")
+		if !ok || key == "" {
+			slog.Warn("hashstore: skipping malformed record",
+				"file", fs.file.Name(), "line", lineno)
+			continue
+		}
+		fs.MemoryStore.Put(key, hash)
+	}
+}
+
+func (fs *FileStore) Put(key string, hash string) error {
+	if key == "" || strings.ContainsAny(key, "\t\n") {
+		return fmt.Errorf("hashstore: key %q empty or contains tab or newline", key)
+	}
+	if strings.ContainsAny(hash, "\t\n") {
+		return fmt.Errorf("hashstore: hash for key %s contains tab or newline", key)
+	}
+	fs.fmut.Lock()
+	defer fs.fmut.Unlock()
+	if _, err := fmt.Fprintf(fs.file, "%s\t%s\n", key, hash); err != nil {
+		return err
+	}
+	if err := fs.file.Sync(); err != nil {
+		return err
+	}
+	return fs.MemoryStore.Put(key, hash)
+}
+
+func (fs *FileStore) Close() error {
+	fs.fmut.Lock()
+	defer fs.fmut.Unlock()
+	return fs.file.Close()
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of the file backed store: what is put is there after reopening,
+// and a log torn by a crash is replayed up to its last whole record.
+//
+
+package hashstore_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stevewahl/GoTest/hashstore"
+)
+
+// openFile -- the FileStore of the log file at path
+func openFile(t *testing.T, path string) *hashstore.FileStore {
+	t.Helper()
+	fs, err := hashstore.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// expectHashes -- fail unless st holds exactly want
+func expectHashes(t *testing.T, st hashstore.HashStore, want map[string]string) {
+	t.Helper()
+	if st.Len() != len(want) {
+		t.Errorf("Len = %d, want %d", st.Len(), len(want))
+	}
+	for key, hash := range want {
+		if got, ok, err := st.Get(key); !ok || err != nil || got != hash {
+			t.Errorf("Get(%s) = %q, %v, %v, want %q", key, got, ok, err, hash)
+		}
+	}
+}
+
+func TestFileStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.log")
+	fs := openFile(t, path)
+	want := map[string]string{
+		"0":                                    "ZEHhWB65gUlzdVwtDQArEyx-KVLzp_aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A-gf7Q==",
+		"9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13": "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"m5hUxk2EIJz3yC7Fp0Q9_w":               "$sha512$c2FsdA$aGFzaA",
+	}
+	for key, hash := range want {
+		if err := fs.Put(key, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a rehash replaces the hash of a key
+	want["0"] = "$pbkdf2-sha512$i=1000$c2FsdA$aGFzaA"
+	if err := fs.Put("0", want["0"]); err != nil {
+		t.Fatal(err)
+	}
+	expectHashes(t, fs, want)
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openFile(t, path)
+	defer fs.Close()
+	expectHashes(t, fs, want)
+	if _, ok, _ := fs.Get("1"); ok {
+		t.Error("Get of a key never put")
+	}
+	for _, bad := range [][2]string{{"", "x"}, {"a\tb", "x"}, {"a\nb", "x"},
+		{"a", "x\ty"}, {"a", "x\ny"}} {
+		if err := fs.Put(bad[0], bad[1]); err == nil {
+			t.Errorf("Put(%q, %q) accepted", bad[0], bad[1])
+		}
+	}
+}
+
+func TestFileStoreTorn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.log")
+	// two whole records, a malformed one, and one cut short by a crash
+	log := "1\t$sha512$c2FsdA$MQ\n" +
+		"2\t$sha512$c2FsdA$Mg\n" +
+		"no tab here\n" +
+		"3\t$sha512$c2Fs"
+	if err := os.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"1": "$sha512$c2FsdA$MQ", "2": "$sha512$c2FsdA$Mg"}
+	fs := openFile(t, path)
+	expectHashes(t, fs, want)
+
+	// a record put after the torn one is one of its own
+	want["4"] = "$sha512$c2FsdA$NA"
+	if err := fs.Put("4", want["4"]); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+	fs = openFile(t, path)
+	defer fs.Close()
+	expectHashes(t, fs, want)
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	for _, spec := range []string{"", "memory",
+		"file:" + filepath.Join(dir, "hashes.log"),
+		"sqlite:" + filepath.Join(dir, "hashes.db")} {
+		st, err := hashstore.Open(spec)
+		if err != nil {
+			t.Errorf("Open(%q): %v", spec, err)
+			continue
+		}
+		st.Close()
+	}
+	for _, spec := range []string{"file", "file:", "sqlite:", "redis:x"} {
+		if _, err := hashstore.Open(spec); err == nil {
+			t.Errorf("Open(%q) accepted", spec)
+		}
+	}
+}
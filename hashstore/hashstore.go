@@ -0,0 +1,59 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Storage for the hashed passwords of the http password hashing servers,
+// retrieved by key, see keys.go.  A store is chosen at startup by a spec string:
+//
+//    memory           -- in-memory map, lost when the process exits
+//    file:<path>      -- append-only log file, replayed when opened
+//    sqlite:<path>    -- SQLite database file, see sql.go
+//
+//    store, err := hashstore.Open("file:/var/lib/hashpw/hashes.log")
+//
+
+package hashstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownStore is returned by Open for an unsupported store spec
+var ErrUnknownStore = errors.New("hashstore: unknown store")
+
+// HashStore -- keyed storage of hashed passwords.  Implementations are
+// safe for concurrent use.
+type HashStore interface {
+	// Get returns the hash stored under key, ok is false if there is none
+	Get(key string) (hash string, ok bool, err error)
+	// Put stores hash under key, replacing any earlier hash
+	Put(key string, hash string) error
+	// Len returns the number of keys stored
+	Len() int
+	// Close releases the store's resources
+	Close() error
+}
+
+// Open -- open the store described by spec, "memory" if spec is empty
+func Open(spec string) (HashStore, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		if arg == "" {
+			return nil, fmt.Errorf("%w: %q needs a file path", ErrUnknownStore, spec)
+		}
+		return OpenFileStore(arg)
+	case "sqlite":
+		if arg == "" {
+			return nil, fmt.Errorf("%w: %q needs a file path", ErrUnknownStore, spec)
+		}
+		return OpenSQLiteStore(arg)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownStore, spec)
+}
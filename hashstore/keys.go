@@ -0,0 +1,84 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Keys of stored hashes.  Keys are random, so that knowing one key tells
+// nothing about the others and the stored hashes cannot be enumerated.
+// The servers' -keyscheme picks the form:
+//
+//    uuid   -- random (version 4) UUID, 9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13
+//    token  -- 128 random bits, unpadded base64url, m5hUxk2EIJz3yC7Fp0Q9_w
+//
+// Hashes stored under the sequential integer keys of earlier versions
+// are kept, and can still be looked up by them, but the servers no longer
+// hand them out.
+//
+
+package hashstore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// key schemes
+const (
+	KeyUUID  = "uuid"
+	KeyToken = "token"
+)
+
+// DefaultKeyScheme -- key scheme of servers not told otherwise
+const DefaultKeyScheme = KeyUUID
+
+// ErrUnknownKeyScheme is returned for a key scheme other than KeySchemes
+var ErrUnknownKeyScheme = errors.New("hashstore: unknown key scheme")
+
+// keyForms -- the syntax of the keys of each scheme
+var keyForms = map[string]*regexp.Regexp{
+	KeyUUID:  regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+	KeyToken: regexp.MustCompile(`^[A-Za-z0-9_-]{21}[AQgw]$`),
+}
+
+// legacyKey -- the sequential integer keys of earlier versions
+var legacyKey = regexp.MustCompile(`^(0|[1-9][0-9]{0,18})$`)
+
+// KeySchemes -- the names of the supported key schemes
+func KeySchemes() []string {
+	return []string{KeyUUID, KeyToken}
+}
+
+// NewKey -- a new random key of scheme
+func NewKey(scheme string) (string, error) {
+	var b [16]byte
+	if _, ok := keyForms[scheme]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKeyScheme, scheme)
+	}
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	if scheme == KeyToken {
+		return base64.RawURLEncoding.EncodeToString(b[:]), nil
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10],
+		b[10:]), nil
+}
+
+// ValidKey -- whether key could have been made by NewKey, of any scheme,
+// or is a legacy integer key, so keys handed out before a change of
+// scheme or version stay good.  For looking keys up only; NewKey never
+// makes legacy keys.
+func ValidKey(key string) bool {
+	for _, form := range keyForms {
+		if form.MatchString(key) {
+			return true
+		}
+	}
+	return legacyKey.MatchString(key)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of the keys of stored hashes: the form of new keys of each
+// scheme, and which keys ValidKey accepts for lookups.
+//
+
+package hashstore_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stevewahl/GoTest/hashstore"
+)
+
+func TestNewKey(t *testing.T) {
+	for _, scheme := range hashstore.KeySchemes() {
+		seen := make(map[string]bool)
+		for range 100 {
+			key, err := hashstore.NewKey(scheme)
+			if err != nil {
+				t.Fatalf("NewKey(%s): %v", scheme, err)
+			}
+			if !hashstore.ValidKey(key) {
+				t.Errorf("NewKey(%s) = %q, not ValidKey", scheme, key)
+			}
+			if seen[key] {
+				t.Errorf("NewKey(%s) = %q twice", scheme, key)
+			}
+			seen[key] = true
+		}
+	}
+	if _, err := hashstore.NewKey("int"); !errors.Is(err, hashstore.ErrUnknownKeyScheme) {
+		t.Errorf("NewKey(int): %v", err)
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13": true,
+		"m5hUxk2EIJz3yC7Fp0Q9_w":               true,
+		// the integer keys of earlier versions, the first of which was 0
+		"0":                   true,
+		"1":                   true,
+		"42":                  true,
+		"9223372036854775807": true,
+		// not keys
+		"":                                     false,
+		"00":                                   false,
+		"042":                                  false,
+		"-1":                                   false,
+		"99999999999999999999":                 false,
+		"9B2E0F4C-7A3D-4E8B-9F61-0C5D2A7E4B13": false,
+		"9b2e0f4c-7a3d-1e8b-9f61-0c5d2a7e4b13": false,
+		"m5hUxk2EIJz3yC7Fp0Q9_x":               false,
+		"not-a-key":                            false,
+		"../42":                                false,
+	} {
+		if got := hashstore.ValidKey(key); got != want {
+			t.Errorf("ValidKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
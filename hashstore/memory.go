@@ -0,0 +1,45 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// In-memory HashStore.  Everything is lost when the process exits.
+//
+
+package hashstore
+
+import "sync"
+
+// MemoryStore -- HashStore kept in a map
+type MemoryStore struct {
+	mut    sync.RWMutex
+	hashes map[string]string
+}
+
+// NewMemoryStore -- an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{hashes: make(map[string]string)}
+}
+
+func (m *MemoryStore) Get(key string) (string, bool, error) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	hash, ok := m.hashes[key]
+	return hash, ok, nil
+}
+
+func (m *MemoryStore) Put(key string, hash string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.hashes[key] = hash
+	return nil
+}
+
+func (m *MemoryStore) Len() int {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return len(m.hashes)
+}
+
+func (m *MemoryStore) Close() error { return nil }
@@ -0,0 +1,170 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// database/sql backed HashStore, on SQLite only.  The schema is
+// created and brought up to date by numbered migrations run when the
+// store is opened; the versions applied are recorded in the
+// schema_migrations table.  Hashes can be read and backed up with the
+// usual SQL tools, e.g.
+//
+//    $ sqlite3 hashes.db 'SELECT hash_key, algorithm, created_at FROM hashes'
+//
+
+package hashstore
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+)
+
+// migrations -- schema changes, migrations[i] takes the schema to version i+1.
+// Only ever append to this list.
+var migrations = []string{
+	`CREATE TABLE hashes (
+		hash_key      INTEGER PRIMARY KEY,
+		hash          TEXT NOT NULL,
+		algorithm     TEXT NOT NULL DEFAULT '',
+		created_at    TIMESTAMP NOT NULL,
+		last_verified TIMESTAMP
+	)`,
+	// keys become opaque strings, see keys.go; the integer keys are kept
+	// and can still be looked up
+	`CREATE TABLE hashes_v2 (
+		hash_key      VARCHAR(64) PRIMARY KEY,
+		hash          TEXT NOT NULL,
+		algorithm     TEXT NOT NULL DEFAULT '',
+		created_at    TIMESTAMP NOT NULL,
+		last_verified TIMESTAMP
+	);
+	INSERT INTO hashes_v2
+		SELECT CAST(hash_key AS CHAR(64)), hash, algorithm, created_at, last_verified
+		FROM hashes;
+	DROP TABLE hashes;
+	ALTER TABLE hashes_v2 RENAME TO hashes`,
+}
+
+// VerifyRecorder -- implemented by stores that keep track of when each
+// hashed password was last successfully verified
+type VerifyRecorder interface {
+	MarkVerified(key string, at time.Time) error
+}
+
+// SQLStore -- HashStore kept in a SQL database table
+type SQLStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteStore -- open, creating if need be, the SQLite database file
+// at path
+func OpenSQLiteStore(path string) (*SQLStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer at a time
+	db.SetMaxOpenConns(1)
+	ss, err := NewSQLStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ss, nil
+}
+
+// NewSQLStore -- a SQLStore on an already opened SQLite database,
+// migrating its schema to the current version.  The SQL used is SQLite's:
+// upserts are "ON CONFLICT ... DO UPDATE" and a migration may run several
+// statements in one Exec, as go-sqlite3 allows, so other databases need
+// their own migrations and Put.
+func NewSQLStore(db *sql.DB) (*SQLStore, error) {
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+// migrate -- apply in order each migration the database has not yet had
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).
+		Scan(&version)
+	if err != nil {
+		return err
+	}
+	for v := version + 1; v <= len(migrations); v++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(migrations[v-1]); err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at)
+				VALUES (?, ?)`, v, time.Now().UTC())
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("hashstore: schema migration %d: %w", v, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		slog.Info("hashstore: applied schema migration", "version", v)
+	}
+	return nil
+}
+
+func (ss *SQLStore) Get(key string) (string, bool, error) {
+	var hash string
+	err := ss.db.QueryRow(`SELECT hash FROM hashes WHERE hash_key = ?`, key).
+		Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return hash, true, nil
+}
+
+func (ss *SQLStore) Put(key string, hash string) error {
+	_, err := ss.db.Exec(`INSERT INTO hashes (hash_key, hash, algorithm, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (hash_key) DO UPDATE
+		SET hash = excluded.hash, algorithm = excluded.algorithm`,
+		key, hash, passhash.AlgorithmOf(hash), time.Now().UTC())
+	return err
+}
+
+// MarkVerified -- record that the hash under key was verified at time at
+func (ss *SQLStore) MarkVerified(key string, at time.Time) error {
+	_, err := ss.db.Exec(`UPDATE hashes SET last_verified = ? WHERE hash_key = ?`,
+		at.UTC(), key)
+	return err
+}
+
+func (ss *SQLStore) Len() int {
+	var n int
+	if err := ss.db.QueryRow(`SELECT COUNT(*) FROM hashes`).Scan(&n); err != nil {
+		slog.Error("hashstore: unable to count hashes", "err", err)
+		return 0
+	}
+	return n
+}
+
+func (ss *SQLStore) Close() error {
+	return ss.db.Close()
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of the SQLite store: a fresh database, one of integer keys
+// migrated with its rows kept, and migrations run only the once.
+//
+
+package hashstore_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stevewahl/GoTest/hashstore"
+)
+
+// openSQLite -- the SQLStore of the database file at path
+func openSQLite(t *testing.T, path string) *hashstore.SQLStore {
+	t.Helper()
+	ss, err := hashstore.OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ss
+}
+
+// queryDB -- run fn on the database file at path, opened beside any store
+func queryDB(t *testing.T, path string, fn func(db *sql.DB) error) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := fn(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// migrationsApplied -- version to applied_at of the migrations recorded in
+// the database file at path
+func migrationsApplied(t *testing.T, path string) map[int]time.Time {
+	t.Helper()
+	applied := map[int]time.Time{}
+	queryDB(t, path, func(db *sql.DB) error {
+		rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v int
+			var at time.Time
+			if err := rows.Scan(&v, &at); err != nil {
+				return err
+			}
+			applied[v] = at
+		}
+		return rows.Err()
+	})
+	return applied
+}
+
+func TestSQLStoreFresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.db")
+	ss := openSQLite(t, path)
+	if ss.Len() != 0 {
+		t.Errorf("Len of a fresh database = %d", ss.Len())
+	}
+	want := map[string]string{
+		"9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13": "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"m5hUxk2EIJz3yC7Fp0Q9_w":               "$sha512$c2FsdA$aGFzaA",
+	}
+	for key, hash := range want {
+		if err := ss.Put(key, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a rehash replaces the hash of a key
+	key := "m5hUxk2EIJz3yC7Fp0Q9_w"
+	want[key] = "$pbkdf2-sha512$i=1000$c2FsdA$aGFzaA"
+	if err := ss.Put(key, want[key]); err != nil {
+		t.Fatal(err)
+	}
+	expectHashes(t, ss, want)
+	if _, ok, err := ss.Get("0"); ok || err != nil {
+		t.Errorf("Get of a key never put = %v, %v", ok, err)
+	}
+	at := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := ss.MarkVerified(key, at); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	queryDB(t, path, func(db *sql.DB) error {
+		var algorithm string
+		var verified time.Time
+		err := db.QueryRow(`SELECT algorithm, last_verified FROM hashes
+			WHERE hash_key = ?`, key).Scan(&algorithm, &verified)
+		if err == nil && (algorithm != "pbkdf2-sha512" || !verified.Equal(at)) {
+			t.Errorf("row of %s: algorithm %q, last_verified %v", key,
+				algorithm, verified)
+		}
+		return err
+	})
+	if applied := migrationsApplied(t, path); len(applied) != 2 {
+		t.Errorf("migrations applied: %v, want versions 1 and 2", applied)
+	}
+	ss = openSQLite(t, path)
+	defer ss.Close()
+	expectHashes(t, ss, want)
+}
+
+func TestSQLStoreMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.db")
+	// a database as left by version 1, with integer keys
+	created := time.Date(2018, 5, 1, 9, 30, 0, 0, time.UTC)
+	queryDB(t, path, func(db *sql.DB) error {
+		for _, stmt := range []string{
+			`CREATE TABLE schema_migrations (
+				version    INTEGER PRIMARY KEY,
+				applied_at TIMESTAMP NOT NULL
+			)`,
+			`INSERT INTO schema_migrations VALUES (1, '2018-05-01 09:00:00')`,
+			`CREATE TABLE hashes (
+				hash_key      INTEGER PRIMARY KEY,
+				hash          TEXT NOT NULL,
+				algorithm     TEXT NOT NULL DEFAULT '',
+				created_at    TIMESTAMP NOT NULL,
+				last_verified TIMESTAMP
+			)`,
+		} {
+			if _, err := db.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		for key, hash := range map[int]string{0: "$sha512$c2FsdA$MA",
+			42: "$sha512$c2FsdA$NDI"} {
+			_, err := db.Exec(`INSERT INTO hashes (hash_key, hash, algorithm,
+				created_at) VALUES (?, ?, 'sha512', ?)`, key, hash, created)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	ss := openSQLite(t, path)
+	want := map[string]string{"0": "$sha512$c2FsdA$MA", "42": "$sha512$c2FsdA$NDI"}
+	expectHashes(t, ss, want)
+	// keys of the new kind go in beside them
+	want["9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13"] = "$sha512$c2FsdA$aGFzaA"
+	if err := ss.Put("9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13",
+		want["9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13"]); err != nil {
+		t.Fatal(err)
+	}
+	expectHashes(t, ss, want)
+	if err := ss.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	queryDB(t, path, func(db *sql.DB) error {
+		var at time.Time
+		err := db.QueryRow(`SELECT created_at FROM hashes WHERE hash_key = '42'`).
+			Scan(&at)
+		if err == nil && !at.Equal(created) {
+			t.Errorf("created_at of 42 = %v, want %v", at, created)
+		}
+		return err
+	})
+	if applied := migrationsApplied(t, path); len(applied) != 2 ||
+		applied[1].Year() != 2018 || applied[2].IsZero() {
+		t.Errorf("migrations applied: %v, want 1 as it was and 2", applied)
+	}
+}
+
+func TestSQLStoreMigrateOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.db")
+	ss := openSQLite(t, path)
+	if err := ss.Put("0", "$sha512$c2FsdA$MA"); err != nil {
+		t.Fatal(err)
+	}
+	ss.Close()
+	before := migrationsApplied(t, path)
+
+	// a second open finds the schema up to date and leaves it be
+	ss = openSQLite(t, path)
+	defer ss.Close()
+	after := migrationsApplied(t, path)
+	if len(after) != len(before) {
+		t.Fatalf("migrations applied: %v, then %v", before, after)
+	}
+	for v, at := range before {
+		if !after[v].Equal(at) {
+			t.Errorf("migration %d reapplied: at %v, then %v", v, at, after[v])
+		}
+	}
+	expectHashes(t, ss, map[string]string{"0": "$sha512$c2FsdA$MA"})
+}
@@ -6,57 +6,111 @@
 //
 // Http password hashing server
 // Server takes a POST request to /hash and returns a Base64 encoded
-// password that has been hashed with SHA512.
+// password that has been hashed with Argon2id
+// (or another algorithm of pwhashutil), in PHC string format.
 // example:
 //	  // start the http server listening to port 8088:
 //	  $ ./httpHashPWsvr_no2 8088
 //
+//	  // or hash with another algorithm than the default Argon2id:
+//	  $ ./httpHashPWsvr_no2 8088 bcrypt
+//
+//	  // or give every setting by flag, in a JSON config file or in HASHPW_*
+//	  // environment variables, and listen on all interfaces or a Unix
+//	  // socket.  See the svrconfig package and -help:
+//	  $ ./httpHashPWsvr_no2 -listen 0.0.0.0:8088 -algorithm scrypt -params ln=16
+//	  $ HASHPW_LISTEN=unix:/run/hashpw.sock ./httpHashPWsvr_no2 -config /etc/hashpw.json
+//
 //	  // issue a client request for the hashed password:
 //	  $ curl --data password="angryMonkey" -X POST http://localhost:8088/hash
-//	  ZEHhWB65gUlzdVwtDQArEyx-KVLzp_aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A-gf7Q==
+//	  $argon2id$v=19$m=65536,t=1,p=4$dZtifBwlMmaUTiWfmEmGxw$xyAC98pq3HtdgEdj0LO91ekLUveC62tNAcpCVepJkbY
 //
 
 package main
 
 import (
+	"flag"
 	"fmt"
 	passhash "github.com/stevewahl/GoTest/pwhashutil"
-	"log"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
+var hasher passhash.Hasher // password hashing algorithm selected at startup
+
 // hashPostReq -- POST response handler to password hash request
 func hashpostreq(rw http.ResponseWriter, req *http.Request) {
+	lg := svrconfig.RequestLogger(req)
 	req.ParseForm()
 	if req.Method != "POST" {
 		http.Error(rw, "ERROR in request to /hash. Must be POST",
 			http.StatusBadRequest)
-		log.Println("non POST method given to /hash request: " +
-			req.Method)
+		lg.Warn("non POST method given to /hash request",
+			"method", req.Method)
 		return
 	}
 	pw := req.Form.Get("password")
 	if len(pw) > 0 {
-		pwhash := passhash.HashifyPW(pw)
+		pwhash, err := hasher.Hash(pw)
+		if err != nil {
+			lg.Error("unable to hash password", "err", err)
+			http.Error(rw, "unable to hash password",
+				http.StatusInternalServerError)
+			return
+		}
 		time.Sleep(time.Millisecond * 5000)
-		log.Println("clear password: " + pw + "	 hashed password: " +
-			pwhash)
+		lg.Debug("password hashed", "algorithm", hasher.Name())
 		fmt.Fprint(rw, pwhash, "\n")
 	} else {
-		log.Println("ERROR in body of POST request.")
+		lg.Warn("no password in body of POST request")
 		http.Error(rw, "expecting body of: \"password=<string>\"",
 			http.StatusBadRequest)
 	}
 }
 
+// usage -- print the command line syntax
+func usage() {
+	fmt.Printf("Usage:  %s [options] [<port_number> [algorithm]]\n", os.Args[0])
+	fmt.Printf("    <port_number>  --  port number for http server to listen on, as -listen\n")
+	fmt.Printf("    [algorithm]    --  one of %s (default %s)\n",
+		strings.Join(passhash.Algorithms(), ", "), passhash.DefaultAlgorithm)
+	fmt.Printf("  options, also settable in the -config file or as %s<OPTION>\n",
+		svrconfig.EnvPrefix)
+	fmt.Printf("  environment variables:\n")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
+	fmt.Printf("\n")
+}
+
 func main() {
-	if len(os.Args) != 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
-		fmt.Printf("Usage:	%s <port_number>\n", os.Args[0])
-		fmt.Printf("  <port_number> -- port for http server to listen to\n\n")
+	cfg := svrconfig.New(flag.CommandLine, 0)
+	flag.Usage = usage
+	flag.Parse()
+	if err := cfg.Load(flag.Args()); err != nil {
+		slog.Error("bad settings", "err", err)
+		usage()
+		os.Exit(1)
+	}
+	if cfg.PrintConfig() {
+		cfg.Write(os.Stdout)
+		os.Exit(0)
+	}
+	var err error
+	if hasher, err = cfg.Hasher(); err != nil {
+		slog.Error("no password hasher", "err", err)
+		os.Exit(1)
+	}
+	ln, err := cfg.Listener()
+	if err != nil {
+		slog.Error("unable to listen", "err", err)
 		os.Exit(1)
 	}
 	http.HandleFunc("/hash", hashpostreq)
-	log.Fatal(http.ListenAndServe("localhost:" + os.Args[1], nil))
+	err = http.Serve(ln, svrconfig.LogRequests(slog.Default(), http.DefaultServeMux))
+	slog.Error("http server", "err", err)
+	os.Exit(1)
 }
@@ -6,52 +6,57 @@
 //
 // Http password hashing server
 // Server takes a POST request to /hash and returns a Base64 encoded
-// password that has been hashed with SHA512.
+// password that has been hashed with Argon2id
+// (or another algorithm of pwhashutil), in PHC string format.
 // example:
 //    // start the http server listening to port 8088:
 //    $ ./httpHashPWsvr_no3 8088
 //
+//    // or hash with another algorithm than the default Argon2id:
+//    $ ./httpHashPWsvr_no3 8088 bcrypt
+//
+//    // or give every setting by flag, in a JSON config file or in HASHPW_*
+//    // environment variables, and listen on all interfaces or a Unix
+//    // socket.  See the svrconfig package and -help:
+//    $ ./httpHashPWsvr_no3 -listen 0.0.0.0:8088 -algorithm scrypt -params ln=16
+//    $ HASHPW_LISTEN=unix:/run/hashpw.sock ./httpHashPWsvr_no3 -config /etc/hashpw.json
+//
 //    // issue a client request for the hashed password:
 //    $ curl --data password="angryMonkey" -X POST http://localhost:8088/hash
-//    ZEHhWB65gUlzdVwtDQArEyx-KVLzp_aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A-gf7Q==
+//    $argon2id$v=19$m=65536,t=1,p=4$dZtifBwlMmaUTiWfmEmGxw$xyAC98pq3HtdgEdj0LO91ekLUveC62tNAcpCVepJkbY
 //
 //    // message to inhibit the server from accepting new password requests
+//    // and then shutdown after the last request has been served.  SIGINT
+//    // and SIGTERM do the same.  The exit status is 1 if that took longer
+//    // than allowed by -drain.
 //    $ curl -X PUT http://localhost:8088/shutdown
 //
 
 package main
 
 import (
+	"flag"
 	"fmt"
 	passhash "github.com/stevewahl/GoTest/pwhashutil"
-	"log"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"log/slog"
 	"net/http"
 	"os"
-	"sync"
+	"strings"
 	"time"
 )
 
 // SHARED DATA BETWEEN FUNCTIONS
-var (
-	mut sync.Mutex    // mutex to safeguard access to nomore flag
-	noMoreFlag bool   // flag if no new messages should be processed
-)
-
-var (
-	cntmut sync.Mutex // mutext to safeguard parallel requests count
-	reqcnt int        // number of requests active
-)
+var hasher passhash.Hasher // password hashing algorithm selected at startup
 
 // hashPostReq -- POST response handler to password hash request
-func hashpostreq(rw http.ResponseWriter, req *http.Request) {
+func hashpostreq(rw http.ResponseWriter, req *http.Request,
+	sd *svrconfig.ShutdownFlag) {
+	lg := svrconfig.RequestLogger(req)
 	req.ParseForm()
 	// see if server is no longer accepting new requests
-	done := false
-	mut.Lock()
-	done = noMoreFlag
-	mut.Unlock()
-	if done {
-		log.Println("Server not accepting new requests at this time.")
+	if sd.Closing() {
+		lg.Warn("server not accepting new requests at this time")
 		http.Error(rw, "Server not accepting new connections at this time.",
 			http.StatusExpectationFailed)
 		return
@@ -60,76 +65,100 @@ func hashpostreq(rw http.ResponseWriter, req *http.Request) {
 	if req.Method != "POST" {
 		http.Error(rw, "ERROR in request to /hash. Must be POST",
 			http.StatusBadRequest)
-		log.Println("non POST method given to /hash request: " +
-			req.Method)
+		lg.Warn("non POST method given to /hash request",
+			"method", req.Method)
 		return
 	}
 	// process the POST request
 	pw := req.Form.Get("password")
 	if len(pw) > 0 {
-		cntmut.Lock()
-		reqcnt += 1
-		cntmut.Unlock()
-		pwhash := passhash.HashifyPW(pw)
+		pwhash, err := hasher.Hash(pw)
 		time.Sleep(time.Millisecond * 5000)
-		log.Println("clear passwod: " + pw + "  hashed password: " +
-			pwhash)
-		fmt.Fprint(rw, pwhash, "\n")
+		if err != nil {
+			lg.Error("unable to hash password", "err", err)
+			http.Error(rw, "unable to hash password",
+				http.StatusInternalServerError)
+		} else {
+			lg.Debug("password hashed", "algorithm", hasher.Name())
+			fmt.Fprint(rw, pwhash, "\n")
+		}
 	} else {
-		log.Println("ERROR in body of POST request.")
+		lg.Warn("no password in body of POST request")
 		http.Error(rw, "expecting body of: \"password=<string>\"",
 			http.StatusBadRequest)
-		return
-	}
-	// decrement outstanding requests
-	cntmut.Lock()
-	reqcnt -= 1
-	cntmut.Unlock()
-	log.Printf("parallel requests = %d\n", reqcnt)
-	// test for server's exit condition
-	if noMoreFlag && reqcnt == 0 {
-		log.Println("Password server exiting")
-		os.Exit(0)
 	}
 }
 
 // shutPutReq -- PUT response handler to allow no more password requests
-func shutsetreq(rw http.ResponseWriter, req *http.Request) {
+func shutsetreq(rw http.ResponseWriter, req *http.Request,
+	sd *svrconfig.ShutdownFlag) {
+	lg := svrconfig.RequestLogger(req)
 	req.ParseForm()
 	if req.Method != "PUT" {
 		http.Error(rw, "ERROR in request to /shutdown. Must be PUT",
 			http.StatusBadRequest)
-		log.Println("non PUT method given to /shutdown request: " +
-			req.Method)
+		lg.Warn("non PUT method given to /shutdown request",
+			"method", req.Method)
 		return
 	}
-	// set the server to no longer accepting new request
-	mut.Lock()
-	noMoreFlag = true
-	mut.Unlock()
-	// test for server's exit condition
-	cntmut.Lock()
-	cnt := reqcnt
-	cntmut.Unlock()
-	if cnt == 0 {
-		http.Error(rw, "Server no longer accepting new requests and exiting.",
-			http.StatusNoContent)
-		log.Println("Password server exiting")
-		time.After(time.Millisecond + 2000)
-		os.Exit(0)
-	}
-	log.Println("Server not accepting new requests at this time.")
-	http.Error(rw, "Server is now no longer accepting new requests.",
-		http.StatusNoContent)
+	// set the server to no longer accepting new request, and have main
+	// drain and stop it once this response is sent
+	lg.Info("server not accepting new requests at this time")
+	sd.Request()
+	rw.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(rw, "Server no longer accepting new requests and exiting ",
+		"once outstanding requests are done.\n")
+}
+
+// usage -- print the command line syntax
+func usage() {
+	fmt.Printf("Usage:  %s [options] [<port_number> [algorithm]]\n", os.Args[0])
+	fmt.Printf("    <port_number>  --  port number for http server to listen on, as -listen\n")
+	fmt.Printf("    [algorithm]    --  one of %s (default %s)\n",
+		strings.Join(passhash.Algorithms(), ", "), passhash.DefaultAlgorithm)
+	fmt.Printf("  options, also settable in the -config file or as %s<OPTION>\n",
+		svrconfig.EnvPrefix)
+	fmt.Printf("  environment variables:\n")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
+	fmt.Printf("\n")
 }
 
 func main() {
-	if len(os.Args) != 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
-		fmt.Printf("Usage:  %s <port_number>\n", os.Args[0])
-		fmt.Printf("    <port_number>  --  port number for http server to listen on\n\n")
+	cfg := svrconfig.New(flag.CommandLine, svrconfig.Drain)
+	flag.Usage = usage
+	flag.Parse()
+	if err := cfg.Load(flag.Args()); err != nil {
+		slog.Error("bad settings", "err", err)
+		usage()
+		os.Exit(1)
+	}
+	if cfg.PrintConfig() {
+		cfg.Write(os.Stdout)
+		os.Exit(0)
+	}
+	var err error
+	if hasher, err = cfg.Hasher(); err != nil {
+		slog.Error("no password hasher", "err", err)
+		os.Exit(1)
+	}
+	ln, err := cfg.Listener()
+	if err != nil {
+		slog.Error("unable to listen", "err", err)
 		os.Exit(1)
 	}
-	http.HandleFunc("/hash", hashpostreq)
-	http.HandleFunc("/shutdown", shutsetreq)
-	log.Fatal(http.ListenAndServe("localhost:"+os.Args[1], nil))
+	// handlers turn away new passwords once sd is requested, and Serve
+	// drains the requests in flight
+	sd := svrconfig.NewShutdownFlag()
+	http.HandleFunc("/hash", func(rw http.ResponseWriter, req *http.Request) {
+		hashpostreq(rw, req, sd)
+	})
+	http.HandleFunc("/shutdown", func(rw http.ResponseWriter, req *http.Request) {
+		shutsetreq(rw, req, sd)
+	})
+	srv := &http.Server{Handler: svrconfig.LogRequests(slog.Default(),
+		http.DefaultServeMux)}
+	status := svrconfig.Serve(srv, ln, sd, cfg.Drain)
+	slog.Info("password server exiting")
+	os.Exit(status)
 }
@@ -6,65 +6,90 @@
 //
 // Http password hashing server
 // Server takes a POST request to /hash and returns a Base64 encoded
-// password that has been hashed with SHA512.
+// password that has been hashed with Argon2id
+// (or another algorithm of pwhashutil), in PHC string format.
 // example:
 //
 //    // start the http server listening to port 8088:
 //    $ ./httpHashPWsvr_no4 8088
 //
+//    // or hash with another algorithm than the default Argon2id:
+//    $ ./httpHashPWsvr_no4 8088 bcrypt
+//
+//    // or give every setting by flag, in a JSON config file or in HASHPW_*
+//    // environment variables, and listen on all interfaces or a Unix
+//    // socket.  See the svrconfig package and -help:
+//    $ ./httpHashPWsvr_no4 -listen 0.0.0.0:8088 -algorithm scrypt -params ln=16
+//    $ HASHPW_LISTEN=unix:/run/hashpw.sock ./httpHashPWsvr_no4 -config /etc/hashpw.json
+//
+//    // keep the hashed passwords in a file, so they survive a restart:
+//    $ ./httpHashPWsvr_no4 8088 argon2id file:/var/lib/hashpw/hashes.log
+//
 //    // issue a client request for the hashed password, retrieving a key to
-//    // the stored hashed password on the server:
+//    // the stored hashed password on the server.  Keys are random UUIDs,
+//    // or with -keyscheme token 128 bit base64url strings:
 //    $ curl --data password="angryMonkey" -X POST http://localhost:8088/hash
-//    42
+//    9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13
 //
 //    // message to inhibit the server from accepting new password requests
-//    // and then shutdown after the last request has been served.
-//    $ curl -X SET http://localhost:8088/shutdown
+//    // and then shutdown after the last request has been served.  SIGINT
+//    // and SIGTERM do the same.  The exit status is 1 if that took longer
+//    // than allowed by -drain.
+//    $ curl -X PUT http://localhost:8088/shutdown
 //
 
 package main
 
 import (
+	"flag"
 	"fmt"
+	"github.com/stevewahl/GoTest/hashstore"
 	passhash "github.com/stevewahl/GoTest/pwhashutil"
-	"log"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"log/slog"
 	"net/http"
 	"os"
-	"sync"
+	"strings"
 	"time"
 )
 
 // SHARED DATA BETWEEN FUNCTIONS
 
-var (
-	mut        sync.Mutex // mutex to safeguard access to noMoreFlag flag
-	noMoreFlag bool       // flag if no new messages should be processed
-)
+var hasher passhash.Hasher // password hashing algorithm selected at startup
 
-var (
-	cntmut sync.Mutex // mutex to safeguard the simultaneous requests count
-	reqcnt int        // number of simultaneous requests being serviced
-)
+var store hashstore.HashStore // hashed password store, retrieved by key
 
-var (
-	mapmut       sync.Mutex // mutex to safeguard adding hashes to a storage map
-	mapLastIndex int        // protected last index value for storage map)
-)
+var keyScheme string // form of new keys, see hashstore/keys.go
 
-var hashmap = make(map[int]string) // hashed password store, retrieved by integer key
+// storeHash -- save a hashed password under key, logging any failure
+func storeHash(key string, pwhash string) {
+	if err := store.Put(key, pwhash); err != nil {
+		slog.Error("unable to store hashed password", "key", key,
+			"err", err)
+	}
+}
+
+// storedHash -- the hashed password saved under key, "" if none
+func storedHash(key string) string {
+	pwhash, _, err := store.Get(key)
+	if err != nil {
+		slog.Error("unable to read hashed password", "key", key,
+			"err", err)
+	}
+	return pwhash
+}
 
 // hashPostReq -- POST response handler to hash and store password, returning key
-func hashpostreq(rw http.ResponseWriter, req *http.Request) {
+func hashpostreq(rw http.ResponseWriter, req *http.Request,
+	sd *svrconfig.ShutdownFlag) {
+	lg := svrconfig.RequestLogger(req)
 	defer req.Body.Close()
 	req.ParseForm()
 	// get the immediate flusher for response buffered writes
 	flusher, _ := rw.(http.Flusher)
 	// see if server is no longer accepting new requests
-	mut.Lock()
-	done := noMoreFlag
-	mut.Unlock()
-	if done {
-		log.Println("Server not accepting new requests at this time.")
+	if sd.Closing() {
+		lg.Warn("server not accepting new requests at this time")
 		http.Error(rw, "Server not accepting new connections at this time.",
 			http.StatusGone)
 		return
@@ -73,89 +98,123 @@ func hashpostreq(rw http.ResponseWriter, req *http.Request) {
 	if req.Method != "POST" {
 		http.Error(rw, "ERROR in request to /hash. Must be POST",
 			http.StatusBadRequest)
-		log.Println("non POST method given to /hash request: " +
-			req.Method)
+		lg.Warn("non POST method given to /hash request",
+			"method", req.Method)
 		return
 	}
 	// process the POST request
 	pw := req.Form.Get("password")
 	if len(pw) > 0 {
-		// increment parallel open server request count
-		cntmut.Lock()
-		reqcnt += 1
-		cntmut.Unlock()
-		// increment and return the retrieval key for this to-be hashed password
-		mapmut.Lock()
-		mapLastIndex += 1
-		mapCurIndex := mapLastIndex
-		hashmap[mapCurIndex] = "check back soon!"
-		mapmut.Unlock()
-		fmt.Fprint(rw, mapCurIndex, "\n")
+		// make and return the retrieval key for this to-be hashed password
+		key, err := hashstore.NewKey(keyScheme)
+		if err != nil {
+			lg.Error("unable to make a key", "err", err)
+			http.Error(rw, "unable to make a key", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(rw, key, "\n")
 		flusher.Flush()
 		// as per instruction, sleep 5 seconds, generate and store the hashed password
 		time.Sleep(5000 * time.Millisecond)
-		hashmap[mapCurIndex] = passhash.HashifyPW(pw)
-		log.Println("clear password: "+pw+" key: ", mapCurIndex,
-			"hashed password: "+hashmap[mapCurIndex])
+		pwhash, err := hasher.Hash(pw)
+		if err != nil {
+			// store nothing, the key is left without a hash
+			lg.Error("unable to hash password", "key", key, "err", err)
+		} else {
+			storeHash(key, pwhash)
+			lg.Debug("password hashed", "key", key, "algorithm", hasher.Name())
+		}
 	} else {
-		log.Println("ERROR in body of POST request.")
+		lg.Warn("no password in body of POST request")
 		http.Error(rw, "expecting body of: \"password=<string>\"",
 			http.StatusBadRequest)
-		return
-	}
-	// decrement outstanding requests
-	cntmut.Lock()
-	reqcnt -= 1
-	cntmut.Unlock()
-	log.Println("parallel requests = ", reqcnt)
-	// test for server's exit condition
-	if noMoreFlag && reqcnt == 0 {
-		log.Println("Password server exiting")
-		os.Exit(0)
 	}
 }
 
 // shutPutReq -- PUT response handler to allow no more password requests
-func shutsetreq(rw http.ResponseWriter, req *http.Request) {
+func shutsetreq(rw http.ResponseWriter, req *http.Request,
+	sd *svrconfig.ShutdownFlag) {
+	lg := svrconfig.RequestLogger(req)
 	defer req.Body.Close()
 	req.ParseForm()
-	flusher, _ := rw.(http.Flusher)
 	if req.Method != "PUT" {
 		http.Error(rw, "ERROR in request to /shutdown. Must be PUT",
 			http.StatusBadRequest)
-		log.Println("non PUT method given to /shutdown request: " +
-			req.Method)
+		lg.Warn("non PUT method given to /shutdown request",
+			"method", req.Method)
 		return
 	}
-	// set the server to no longer accepting new request
-	mut.Lock()
-	noMoreFlag = true
-	mut.Unlock()
-	// test for server's exit condition
-	cntmut.Lock()
-	cnt := reqcnt
-	cntmut.Unlock()
-	if cnt == 0 {
-		http.Error(rw, "Server no longer accepting new requests and exiting.",
-			http.StatusGone)
-		flusher.Flush()
-		log.Println("Password server exiting")
-		time.Sleep(2000 * time.Millisecond)
-		os.Exit(0)
-	}
-	log.Println("Server not accepting new requests at this time.")
-	http.Error(rw, "Server is now no longer accepting new requests.",
-		http.StatusGone)
+	// set the server to no longer accepting new request, and have main
+	// drain and stop it once this response is sent
+	lg.Info("server not accepting new requests at this time")
+	sd.Request()
+	rw.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(rw, "Server no longer accepting new requests and exiting ",
+		"once outstanding requests are done.\n")
+}
+
+// usage -- print the command line syntax
+func usage() {
+	fmt.Printf("Usage:  %s [options] [<port_number> [algorithm [store]]]\n", os.Args[0])
+	fmt.Printf("    <port_number>  --  port number for http server to listen on, as -listen\n")
+	fmt.Printf("    [algorithm]    --  one of %s (default %s)\n",
+		strings.Join(passhash.Algorithms(), ", "), passhash.DefaultAlgorithm)
+	fmt.Printf("    [store]        --  \"memory\" (default), or to keep hashed passwords\n")
+	fmt.Printf("                       across restarts \"file:<path>\" for a log file\n")
+	fmt.Printf("                       or \"sqlite:<path>\" for a SQLite database\n")
+	fmt.Printf("  options, also settable in the -config file or as %s<OPTION>\n",
+		svrconfig.EnvPrefix)
+	fmt.Printf("  environment variables:\n")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
+	fmt.Printf("\n")
 }
 
 func main() {
-	if len(os.Args) != 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
-		fmt.Printf("Usage:  %s <port_number>\n", os.Args[0])
-		fmt.Printf("    <port_number>  --  port number for http server to listen on\n\n")
+	cfg := svrconfig.New(flag.CommandLine, svrconfig.Drain|svrconfig.Store)
+	flag.Usage = usage
+	flag.Parse()
+	if err := cfg.Load(flag.Args()); err != nil {
+		slog.Error("bad settings", "err", err)
+		usage()
 		os.Exit(1)
 	}
-	mapLastIndex = -1
-	http.HandleFunc("/hash", hashpostreq)
-	http.HandleFunc("/shutdown", shutsetreq)
-	log.Fatal(http.ListenAndServe("localhost:"+os.Args[1], nil))
+	if cfg.PrintConfig() {
+		cfg.Write(os.Stdout)
+		os.Exit(0)
+	}
+	var err error
+	if hasher, err = cfg.Hasher(); err != nil {
+		slog.Error("no password hasher", "err", err)
+		os.Exit(1)
+	}
+	if store, err = hashstore.Open(cfg.Store); err != nil {
+		slog.Error("unable to open the hashed password store", "err", err)
+		os.Exit(1)
+	}
+	keyScheme = cfg.KeyScheme
+	ln, err := cfg.Listener()
+	if err != nil {
+		store.Close()
+		slog.Error("unable to listen", "err", err)
+		os.Exit(1)
+	}
+	// handlers turn away new passwords once sd is requested, and Serve
+	// drains the requests in flight
+	sd := svrconfig.NewShutdownFlag()
+	http.HandleFunc("/hash", func(rw http.ResponseWriter, req *http.Request) {
+		hashpostreq(rw, req, sd)
+	})
+	http.HandleFunc("/shutdown", func(rw http.ResponseWriter, req *http.Request) {
+		shutsetreq(rw, req, sd)
+	})
+	srv := &http.Server{Handler: svrconfig.LogRequests(slog.Default(),
+		http.DefaultServeMux)}
+	status := svrconfig.Serve(srv, ln, sd, cfg.Drain)
+	if err := store.Close(); err != nil {
+		slog.Error("closing the hashed password store", "err", err)
+		status = 1
+	}
+	slog.Info("password server exiting")
+	os.Exit(status)
 }
@@ -6,7 +6,8 @@
 //
 // Http password hashing server
 // Server takes a POST request to /hash and returns an key for retrieving
-// the Base64 encoded password that has been hashed with SHA512.
+// the Base64 encoded password that has been hashed with Argon2id
+// (or another algorithm of pwhashutil), in PHC string format.
 // A GET request to the server with /hash/{keyvalue} will return the
 // hashed password.
 //
@@ -14,245 +15,225 @@
 //    // start the http server listening to port 8088:
 //    $ ./httpHashPWsvr_no6 8088
 //
+//    // or hash with another algorithm than the default Argon2id:
+//    $ ./httpHashPWsvr_no6 8088 bcrypt
+//
+//    // keep the hashed passwords in a file, so they survive a restart:
+//    $ ./httpHashPWsvr_no6 8088 argon2id file:/var/lib/hashpw/hashes.log
+//
+//    // passwords are hashed by a pool of workers fed from a bounded queue,
+//    // both sizes can be set:
+//    $ ./httpHashPWsvr_no6 -workers 8 -queue 1000 8088
+//
+//    // or give every setting by flag, in a JSON config file or in HASHPW_*
+//    // environment variables, and listen on all interfaces or a Unix
+//    // socket.  See the svrconfig package and -help:
+//    $ ./httpHashPWsvr_no6 -listen 0.0.0.0:8088 -algorithm scrypt -params ln=16
+//    $ HASHPW_LISTEN=unix:/run/hashpw.sock ./httpHashPWsvr_no6 -config /etc/hashpw.json
+//    $ ./httpHashPWsvr_no6 -config /etc/hashpw.json -print-config
+//
+//    // serve https, optionally only to clients with a certificate from
+//    // clients-ca.pem.  Certificates are reloaded on SIGHUP or change:
+//    $ ./httpHashPWsvr_no6 -listen :8443 -tlscert server.pem -tlskey server.key \
+//        -tlsclientca clients-ca.pem
+//
 //    // issue a client request for the hashed password, retrieving a key to
-//    // the stored hashed password on the server:
+//    // the stored hashed password on the server.  The password is hashed
+//    // after the key is returned; when the queue of passwords waiting to
+//    // be hashed is full the request fails with 503 and a Retry-After header.
+//    // Keys are random UUIDs, or with -keyscheme token 128 bit base64url
+//    // strings, so stored hashes cannot be found by guessing keys.
 //    $ curl --data password="angryMonkey" -X POST http://localhost:8088/hash
-//    42
+//    9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13
+//
+//    // or send the password as JSON, optionally with the algorithm and cost
+//    // parameters to hash it with:
+//    $ curl -H "Content-Type: application/json" -X POST http://localhost:8088/hash \
+//        --data '{"password": "angryMonkey", "algorithm": "bcrypt", "params": {"cost": 12}}'
+//    3f0a6c1e-52d4-4b9a-8e07-d1c9b26f5a80
+//
+//    // clients may ask for every algorithm but sha512, at up to four times
+//    // the work of the server's own parameters; beyond that they get 400
+//    // "not_allowed".  Both can be set:
+//    $ ./httpHashPWsvr_no6 -allow argon2id,scrypt -maxparams argon2id.m=131072,argon2id.t=3 8088
 //
 //    // retrieve a stored hashed password from the http server by adding
-//    // the key to the "/hash" service like "/hash/42".  Example:
-//    $ curl -X GET http://localhost:8088/hash/42
-//    ZEHhWB65gUlzdVwtDQArEyx-KVLzp_aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A-gf7Q==
+//    // the key to the "/hash" service like "/hash/{key}".  Until the password
+//    // has been hashed the answer is "pending" with status 202.  Example:
+//    $ curl -X GET http://localhost:8088/hash/$KEY
+//    $argon2id$v=19$m=65536,t=1,p=4$dZtifBwlMmaUTiWfmEmGxw$xyAC98pq3HtdgEdj0LO91ekLUveC62tNAcpCVepJkbY
+//
+//    // the hash job for a key is queued, running, done, failed or expired
+//    // (left waiting longer than -jobtimeout).  Its status, as JSON:
+//    $ curl -X GET http://localhost:8088/hash/$KEY/status
+//    {"key":"9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13","status":"done","queued_at":"2018-...","started_at":"2018-...","finished_at":"2018-..."}
+//
+//    // check a password against a stored hash, by key or by the encoded
+//    // hash itself.  Answers "match" or "no match".  A matching stored
+//    // hash made with other than the server's current algorithm and cost
+//    // parameters is replaced by a fresh hash of the password.
+//    $ curl --data key=$KEY --data password="angryMonkey" -X POST http://localhost:8088/verify
+//    match
+//    $ curl --data-urlencode hash='$argon2id$v=19$...' --data password="angryMonkey" \
+//        -X POST http://localhost:8088/verify
+//    match
 //
 //    // retrieve JSON response to a /stats GET request of total number of
 //    // hash requests and the average time in milliseconds it takes to process
 //    // a hash request based upon all prior session hash request times
 //    $ curl -X GET http://localhost:8088/stats
-//    {"total": 1, "average": 123}
+//    {"total":1,"average":123,"in_flight":1,"queue_depth":0,"queue_capacity":100,
+//     "requests":{"/hash":{"count":2,"errors":{"400":1}},"/stats":{"count":1}},
+//     "errors":{"400":1},"latency":{"count":1,"p50":123,"p90":123,"p99":123,"max":123}}
+//
+//    // with a -store kept across restarts, total also counts the hashes
+//    // stored before start, given as "stored", whose times are unknown
+//    // and left out of the average
+//
+//    // the same for the last 1, 5 or 15 minutes only, where total and
+//    // average count the hash jobs finished in that time:
+//    $ curl -X GET http://localhost:8088/stats?window=5m
+//
+//    // the same figures, and per algorithm hash latency histograms, in the
+//    // Prometheus text format for scraping:
+//    $ curl -X GET http://localhost:8088/metrics
+//
+//    // limit each client to 5 hash and verify requests a second, and the
+//    // server to hashing 4 passwords at once.  Over the limits requests
+//    // get 429 and a Retry-After header, see hashserver/ratelimit.go:
+//    $ ./httpHashPWsvr_no6 -ratelimit 5 -burst 20 -maxhashing 4 8088
+//
+//    // log as JSON lines, one per request with its X-Request-ID, method,
+//    // path, status and latency; passwords are never logged:
+//    $ ./httpHashPWsvr_no6 -logformat json -loglevel debug 8088
+//
+//    // with -authfile clients must present an API key, bearer token or
+//    // client certificate having the role an endpoint needs, see hashserver/auth.go:
+//    $ curl -H "Authorization: Bearer $TOKEN" -X GET http://localhost:8088/hash/$KEY
+//
+//    // record who hashed, retrieved or verified which key, and who shut
+//    // the server down, in a hash-chained audit log, see hashserver/audit.go:
+//    $ ./httpHashPWsvr_no6 -auditlog /var/log/hashpw-audit.log -authfile auth.json 8088
+//    $ ./auditVerifyCmd /var/log/hashpw-audit.log
+//
+//    // every endpoint answers in JSON for clients that ask for it:
+//    $ curl -H "Accept: application/json" http://localhost:8088/hash/$KEY
+//    {"key":"9b2e0f4c-7a3d-4e8b-9f61-0c5d2a7e4b13","status":"done","hash":"$argon2id$v=19$...","algorithm":"argon2id"}
 //
 //    // message to inhibit the server from accepting new password requests
-//    // and then shutdown after the last POST request has been served.
-//    $ curl -X SET http://localhost:8088/shutdown
+//    // and then shutdown after the last POST request has been served and
+//    // the queued passwords hashed.  SIGINT and SIGTERM do the same.  The
+//    // exit status is 1 if that took longer than allowed by -drain.
+//    $ curl -X PUT http://localhost:8088/shutdown
+//
+// The service itself is the hashserver package; this is the command
+// serving it.
 //
 
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"github.com/stevewahl/GoTest/audit"
+	"github.com/stevewahl/GoTest/hashserver"
+	"github.com/stevewahl/GoTest/hashstore"
 	passhash "github.com/stevewahl/GoTest/pwhashutil"
-	"log"
+	"github.com/stevewahl/GoTest/svrconfig"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
-	"sync"
-	"time"
-	"unicode"
-	// "encoding/json"
 )
 
-// SHARED DATA BETWEEN FUNCTIONS
-
-type Stats struct {
-	total   int
-	average int
+// usage -- print the command line syntax
+func usage() {
+	fmt.Printf("Usage:  %s [options] [<port_number> [algorithm [store]]]\n", os.Args[0])
+	fmt.Printf("    <port_number>  --  port number for http server to listen on, as -listen\n")
+	fmt.Printf("    [algorithm]    --  one of %s (default %s)\n",
+		strings.Join(passhash.Algorithms(), ", "), passhash.DefaultAlgorithm)
+	fmt.Printf("    [store]        --  \"memory\" (default), or to keep hashed passwords\n")
+	fmt.Printf("                       across restarts \"file:<path>\" for a log file\n")
+	fmt.Printf("                       or \"sqlite:<path>\" for a SQLite database\n")
+	fmt.Printf("  options, also settable in the -config file or as %s<OPTION>\n",
+		svrconfig.EnvPrefix)
+	fmt.Printf("  environment variables:\n")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
+	fmt.Printf("\n")
 }
 
-var (
-	mut sync.Mutex    // mutex to safeguard access to noMoreFlag flag
-	noMoreFlag bool   // flag if no new messages should be processed
-)
-
-var (
-	cntmut sync.Mutex // mutex to safeguard the simultaneous requests count
-	reqcnt int        // number of simultaneous requests being serviced
-)
-
-var (
-	mapmut sync.Mutex     // mutex to safeguard adding hashes to a storage map
-	mapLastIndex   int    // protected last index value for storage map)
-	mapTotDuration int64  // total millisecond spent processing hash POST requests
-)
-
-var hashmap = make(map[int]string) // hashed password store, retrieved by integer key
-
-// isInt -- Need to ensure the key is only digits, strconv.Atoi returns valid 0 on failure
-func isInt(s string) bool {
-	for _, c := range s {
-		if !unicode.IsDigit(c) {
-			return false
-		}
+func main() {
+	cfg := svrconfig.New(flag.CommandLine,
+		svrconfig.Drain|svrconfig.Store|svrconfig.Jobs|svrconfig.Auth|
+			svrconfig.Limits|svrconfig.Audit|svrconfig.Policy)
+	flag.Usage = usage
+	flag.Parse()
+	if err := cfg.Load(flag.Args()); err != nil {
+		slog.Error("bad settings", "err", err)
+		usage()
+		os.Exit(1)
 	}
-	return true
-}
-
-// hashGetReq -- GET response handler to retrieve stored hashed passwords
-func hashGetReq(rw http.ResponseWriter, req *http.Request) {
-	flusher := rw.(http.Flusher)
-	req.ParseForm()
-	key := -1
-	// return a previously generated hashed password string.
-	if req.Method == "GET" {
-		pathArray := strings.Split(req.URL.Path, "/")
-		pathlen := len(pathArray)
-		if pathlen > 2 {
-			if isInt(pathArray[2]) {
-				key, _ = strconv.Atoi(pathArray[2])
-			}
-		}
-		mapmut.Lock()
-		lastindex := mapLastIndex
-		mapmut.Unlock()
-		if key < 0 || key > lastindex {
-			log.Println("ERROR -- GET missing or invalid HASHED PASSWORD key value")
-			http.Error(rw, "GET method missing or invalid Hashed Password key",
-				http.StatusBadRequest)
-		} else {
-			fmt.Fprint(rw, hashmap[key], "\n")
-			flusher.Flush()
-		}
-	} else {
-		http.Error(rw, "ERROR in request to /hash. Must be POST or GET",
-			http.StatusMethodNotAllowed)
-		log.Println("non POST method given to /hash request: " +
-			req.Method)
+	if cfg.PrintConfig() {
+		cfg.Write(os.Stdout)
+		os.Exit(0)
 	}
-}
-
-// hashPostReq -- POST response handler to hash and store password, returning key
-func hashPostReq(rw http.ResponseWriter, req *http.Request) {
-	flusher := rw.(http.Flusher)
-	req.ParseForm()
-	if req.Method == "POST" {
-		// see if server is no longer accepting new requests
-		mut.Lock()
-		done := noMoreFlag
-		mut.Unlock()
-		if done {
-			log.Println("Server not accepting new requests at this time.")
-			http.Error(rw, "Server not accepting new connections at this time.",
-				http.StatusExpectationFailed)
-		} else {
-			// process the POST request
-			pw := req.Form.Get("password")
-			starttime := time.Now()
-			if len(pw) == 0 {
-				log.Println("ERROR -- POST body missing \"password=<string>\".")
-				http.Error(rw, "expecting body of: \"password=<string>\"",
-					http.StatusBadRequest)
-				return
-			}
-			// increment parallel open server request count
-			cntmut.Lock()
-			reqcnt += 1
-			cntmut.Unlock()
-			// increment and return the retrieval key for this to-be hashed password
-			mapmut.Lock()
-			mapLastIndex += 1
-			mapCurIndex := mapLastIndex
-			mapmut.Unlock()
-			fmt.Fprint(rw, strconv.Itoa(mapCurIndex), "\n")
-			flusher.Flush()
-			// as per instruction, sleep 5 seconds, generate and store the hashed pw
-			// time.Sleep(5000 * time.Millisecond)
-			hashmap[mapCurIndex] = passhash.HashifyPW(pw)
-			log.Println("clear passwod: "+pw+" key: ", mapCurIndex,
-				"hashed password: "+hashmap[mapCurIndex])
-			// decrement outstanding requests
-			cntmut.Lock()
-			reqcnt -= 1
-			cntmut.Unlock()
-			log.Println("parallel requests = ", reqcnt)
-			timenow := time.Now()
-			mapmut.Lock()
-			mapTotDuration += int64(timenow.Sub(starttime))
-			mapmut.Unlock()
-			// test for server's exit condition
-			if noMoreFlag && reqcnt == 0 {
-				log.Println("Password server exiting")
-				os.Exit(0)
-			}
-		}
-	} else {
-		http.Error(rw, "ERROR in request to /hash. Must be POST",
-			http.StatusMethodNotAllowed)
-		log.Println("non POST method given to /hash request: " +
-			req.Method)
+	hasher, err := cfg.Hasher()
+	if err != nil {
+		slog.Error("no password hasher", "err", err)
+		os.Exit(1)
 	}
-}
-
-// statsGetReq -- return JSON packet of hash requests count and average
-//                milliseconds per request
-func statsGetReq(rw http.ResponseWriter, req *http.Request) {
-	req.ParseForm()
-	avMils := 0
-	if req.Method == "GET" {
-		mapmut.Lock()
-		count := 1 + mapLastIndex
-		if count > 0 {
-			// using microsec rather than millisec as my averages < 1 millisecond
-			avMils = int(mapTotDuration/1000) / count
+	policy, err := cfg.Policy()
+	if err != nil {
+		slog.Error("no hashing policy", "err", err)
+		os.Exit(1)
+	}
+	store, err := hashstore.Open(cfg.Store)
+	if err != nil {
+		slog.Error("unable to open the hashed password store", "err", err)
+		os.Exit(1)
+	}
+	opts := []hashserver.Option{hashserver.WithStore(store),
+		hashserver.WithHasher(hasher), hashserver.WithPolicy(policy),
+		hashserver.WithKeyScheme(cfg.KeyScheme),
+		hashserver.WithWorkers(cfg.Workers, cfg.Queue),
+		hashserver.WithJobTimeout(cfg.JobTimeout),
+		hashserver.WithStatusTTL(cfg.StatusTTL),
+		hashserver.WithLimits(cfg.RateLimit, cfg.Burst, cfg.MaxHashing)}
+	if cfg.AuthFile != "" {
+		opts = append(opts, hashserver.WithAuthFile(cfg.AuthFile))
+	}
+	var auditLog *audit.Log
+	if cfg.AuditLog != "" {
+		auditLog, err = audit.Open(cfg.AuditLog, int64(cfg.AuditMax)<<20,
+			cfg.AuditKeep)
+		if err != nil {
+			store.Close()
+			slog.Error("unable to open the audit log", "err", err)
+			os.Exit(1)
 		}
-		mapmut.Unlock()
-		s := Stats{}
-		s.total = count
-		s.average = avMils
-		// FIXME -- js, _ := json.Marshal(s)
-		// sorry, can't figure out why json.Marshal() isn't working for me.
-		// in a hurry, so, I'll do it by hand.
-		st := fmt.Sprintf("{\"total\": %d, \"average\": %d}\n",
-			count, avMils)
-		rw.Header().Set("Content-Type", "application/json")
-		//rw.Write(js)
-		rw.Write([]byte(st))
-	} else {
-		http.Error(rw, "ERROR in request to /stats. Must be GET method",
-			http.StatusMethodNotAllowed)
-		log.Println("non POST method given to /hash request: " +
-			req.Method)
+		opts = append(opts, hashserver.WithAuditLog(auditLog))
 	}
-}
-
-// shutPutReq -- PUT response handler to allow no more password requests
-func shutPutReq(rw http.ResponseWriter, req *http.Request) {
-	req.ParseForm()
-	if req.Method != "PUT" {
-		http.Error(rw, "ERROR in request to /shutdown. Must be PUT",
-			http.StatusMethodNotAllowed)
-		log.Println("non PUT method given to /shutdown request: " +
-			req.Method)
-		return
+	status := 1
+	if hs, err := hashserver.New(opts...); err != nil {
+		slog.Error("unable to start the password server", "err", err)
+	} else if ln, err := cfg.Listener(); err != nil {
+		slog.Error("unable to listen", "err", err)
+		hs.Shutdown(context.Background())
+	} else {
+		status = svrconfig.Serve(&http.Server{Handler: hs}, ln, hs, cfg.Drain)
 	}
-	// set the server to no longer accepting new request
-	mut.Lock()
-	noMoreFlag = true
-	mut.Unlock()
-	// test for server's exit condition
-	cntmut.Lock()
-	cnt := reqcnt
-	cntmut.Unlock()
-	if cnt == 0 {
-		http.Error(rw, "Server no longer accepting new requests and exiting.",
-			http.StatusExpectationFailed)
-		log.Println("Password server exiting")
-		time.Sleep(2000 * time.Millisecond)
-		os.Exit(0)
+	if err := store.Close(); err != nil {
+		slog.Error("closing the hashed password store", "err", err)
+		status = 1
 	}
-	log.Println("Server not accepting new requests at this time.")
-	http.Error(rw, "Server is now no longer accepting new requests.",
-		http.StatusExpectationFailed)
-}
-
-func main() {
-	if len(os.Args) != 2 {
-		fmt.Printf("Usage:  %s <port_number>\n", os.Args[0])
-		fmt.Printf("    <port_number>  --  port number for http server to listen on\n\n")
-		os.Exit(1)
+	if auditLog != nil {
+		if err := auditLog.Close(); err != nil {
+			slog.Error("closing the audit log", "err", err)
+			status = 1
+		}
 	}
-	mapLastIndex = -1
-	http.HandleFunc("/hash", hashPostReq)
-	http.HandleFunc("/hash/", hashGetReq)
-	http.HandleFunc("/stats", statsGetReq)
-	http.HandleFunc("/shutdown", shutPutReq)
-	log.Fatal(http.ListenAndServe("localhost:"+os.Args[1], nil))
+	slog.Info("password server exiting")
+	os.Exit(status)
 }
@@ -0,0 +1,326 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Hasher interface and its implementations for the supported password
+// hashing algorithms.  A Hasher is selected by name:
+//
+//    h, err := pwhashutil.NewHasher("argon2id")
+//    ...
+//    encoded, err := h.Hash("angryMonkey")
+//
+// Every call to Hash uses a freshly generated random salt, and the result
+// is a PHC format string (see phc.go) carrying the algorithm, its cost
+// parameters and the salt along with the hash, e.g.
+//    $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+// bcrypt keeps its own well known "$2a$<cost>$<salt+hash>" format.
+//
+
+package pwhashutil
+
+import (
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// algorithm names accepted by NewHasher
+const (
+	SHA512       = "sha512"
+	Bcrypt       = "bcrypt"
+	Scrypt       = "scrypt"
+	Argon2id     = "argon2id"
+	PBKDF2SHA512 = "pbkdf2-sha512"
+)
+
+// DefaultAlgorithm -- algorithm used when none is asked for
+const DefaultAlgorithm = Argon2id
+
+// DefaultSaltLen -- bytes of random salt generated for each hash
+const DefaultSaltLen = 16
+
+// ErrUnknownAlgorithm is returned by NewHasher for an unsupported name
+var ErrUnknownAlgorithm = errors.New("pwhashutil: unknown hashing algorithm")
+
+// Hasher -- a password hashing algorithm with its cost parameters
+type Hasher interface {
+	// Name returns the algorithm name as accepted by NewHasher
+	Name() string
+	// Hash returns the encoded hash of the clear-text password
+	Hash(clearpw string) (string, error)
+}
+
+// Algorithms -- names of all the algorithms NewHasher knows about
+func Algorithms() []string {
+	return []string{SHA512, Bcrypt, Scrypt, Argon2id, PBKDF2SHA512}
+}
+
+// NewHasher -- return the Hasher for the named algorithm, set up with
+// this package's default cost parameters.  Names are case insensitive.
+func NewHasher(name string) (Hasher, error) {
+	switch strings.ToLower(name) {
+	case SHA512:
+		return SHA512Hasher{SaltLen: DefaultSaltLen}, nil
+	case Bcrypt:
+		return BcryptHasher{Cost: bcrypt.DefaultCost}, nil
+	case Scrypt:
+		return ScryptHasher{N: 32768, R: 8, P: 1, KeyLen: 32,
+			SaltLen: DefaultSaltLen}, nil
+	case Argon2id:
+		return Argon2idHasher{Time: 1, Memory: 64 * 1024, Threads: 4,
+			KeyLen: 32, SaltLen: DefaultSaltLen}, nil
+	case PBKDF2SHA512:
+		return PBKDF2Hasher{Iterations: 210000, KeyLen: 64,
+			SaltLen: DefaultSaltLen}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, name)
+}
+
+// ErrBadParams is returned by NewHasherParams for unknown or out of
+// range cost parameters
+var ErrBadParams = errors.New("pwhashutil: bad hashing parameters")
+
+// NewHasherParams -- like NewHasher, with the defaults overridden by the
+// given cost parameters.  Parameters are named as in the algorithm's
+// encoded hashes: bcrypt "cost"; scrypt "ln", "r", "p"; argon2id "m",
+// "t", "p"; pbkdf2-sha512 "i".  Values are limited to the same ranges
+// Verify accepts.
+func NewHasherParams(name string, params map[string]int) (Hasher, error) {
+	h, err := NewHasher(name)
+	if err != nil || len(params) == 0 {
+		return h, err
+	}
+	bad := func(prm string) error {
+		return fmt.Errorf("%w: %s %q=%d", ErrBadParams, h.Name(), prm,
+			params[prm])
+	}
+	inRange := func(prm string, lo, hi int) bool {
+		v, ok := params[prm]
+		return !ok || (v >= lo && v <= hi)
+	}
+	known := map[string]bool{}
+	switch hh := h.(type) {
+	case SHA512Hasher:
+	case BcryptHasher:
+		known["cost"] = true
+		if !inRange("cost", bcrypt.MinCost, maxBcryptCost) {
+			return nil, bad("cost")
+		}
+		if v, ok := params["cost"]; ok {
+			hh.Cost = v
+		}
+		h = hh
+	case ScryptHasher:
+		known["ln"], known["r"], known["p"] = true, true, true
+		ln := costParams(hh)["ln"]
+		if v, ok := params["ln"]; ok {
+			ln = v
+		}
+		if v, ok := params["r"]; ok {
+			hh.R = v
+		}
+		if v, ok := params["p"]; ok {
+			hh.P = v
+		}
+		if !scryptInRange(ln, hh.R, hh.P) {
+			return nil, fmt.Errorf("%w: scrypt ln=%d,r=%d,p=%d", ErrBadParams,
+				ln, hh.R, hh.P)
+		}
+		hh.N = 1 << ln
+		h = hh
+	case Argon2idHasher:
+		known["m"], known["t"], known["p"] = true, true, true
+		if !inRange("t", 1, maxArgonTime) {
+			return nil, bad("t")
+		}
+		if !inRange("p", 1, 255) {
+			return nil, bad("p")
+		}
+		if v, ok := params["t"]; ok {
+			hh.Time = uint32(v)
+		}
+		if v, ok := params["p"]; ok {
+			hh.Threads = uint8(v)
+		}
+		if !inRange("m", 8*int(hh.Threads), maxArgonMem) {
+			return nil, bad("m")
+		}
+		if v, ok := params["m"]; ok {
+			hh.Memory = uint32(v)
+		}
+		h = hh
+	case PBKDF2Hasher:
+		known["i"] = true
+		if !inRange("i", 1000, maxPBKDF2Iter) {
+			return nil, bad("i")
+		}
+		if v, ok := params["i"]; ok {
+			hh.Iterations = v
+		}
+		h = hh
+	}
+	for prm := range params {
+		if !known[prm] {
+			return nil, fmt.Errorf("%w: %s has no parameter %q", ErrBadParams,
+				h.Name(), prm)
+		}
+	}
+	return h, nil
+}
+
+// kdf -- a Hasher producing a PHC string, with the key derivation split
+// out so Verify can rerun it using a stored salt
+type kdf interface {
+	Hasher
+	derive(clearpw string, salt []byte) ([]byte, error)
+	encode(salt, dk []byte) PHC
+}
+
+// hashPHC -- derive a key for clearpw with a new random salt
+func hashPHC(h kdf, clearpw string, saltLen int) (string, error) {
+	salt, err := newSalt(saltLen)
+	if err != nil {
+		return "", err
+	}
+	dk, err := h.derive(clearpw, salt)
+	if err != nil {
+		return "", err
+	}
+	return h.encode(salt, dk).String(), nil
+}
+
+// SHA512Hasher -- a single pass of SHA512 over salt+password.  Only kept
+// for compatibility; it is far too fast to be a good password hash.
+//
+//	$sha512$<salt>$<hash>
+type SHA512Hasher struct {
+	SaltLen int
+}
+
+func (SHA512Hasher) Name() string { return SHA512 }
+
+func (h SHA512Hasher) Hash(clearpw string) (string, error) {
+	return hashPHC(h, clearpw, h.SaltLen)
+}
+
+func (SHA512Hasher) derive(clearpw string, salt []byte) ([]byte, error) {
+	sha_512 := sha512.New()
+	sha_512.Write(salt)
+	sha_512.Write([]byte(clearpw))
+	return sha_512.Sum(nil), nil
+}
+
+func (SHA512Hasher) encode(salt, dk []byte) PHC {
+	return PHC{ID: SHA512, Salt: salt, Hash: dk}
+}
+
+// BcryptHasher -- bcrypt at the given cost, producing the usual
+// "$2a$<cost>$..." string which carries its own salt
+type BcryptHasher struct {
+	Cost int
+}
+
+func (BcryptHasher) Name() string { return Bcrypt }
+
+func (h BcryptHasher) Hash(clearpw string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(clearpw), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ScryptHasher -- scrypt with CPU/memory cost N (a power of two), block
+// size R and parallelism P.
+//
+//	$scrypt$ln=<log2 N>,r=<R>,p=<P>$<salt>$<hash>
+type ScryptHasher struct {
+	N, R, P int
+	KeyLen  int
+	SaltLen int
+}
+
+func (ScryptHasher) Name() string { return Scrypt }
+
+func (h ScryptHasher) Hash(clearpw string) (string, error) {
+	return hashPHC(h, clearpw, h.SaltLen)
+}
+
+func (h ScryptHasher) derive(clearpw string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(clearpw), salt, h.N, h.R, h.P, h.KeyLen)
+}
+
+func (h ScryptHasher) encode(salt, dk []byte) PHC {
+	return PHC{ID: Scrypt, Params: []Param{
+		{"ln", strconv.Itoa(bits.Len(uint(h.N)) - 1)},
+		{"r", strconv.Itoa(h.R)},
+		{"p", strconv.Itoa(h.P)},
+	}, Salt: salt, Hash: dk}
+}
+
+// Argon2idHasher -- Argon2id with Time passes over Memory KiB using
+// Threads lanes.
+//
+//	$argon2id$v=19$m=<Memory>,t=<Time>,p=<Threads>$<salt>$<hash>
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen int
+}
+
+func (Argon2idHasher) Name() string { return Argon2id }
+
+func (h Argon2idHasher) Hash(clearpw string) (string, error) {
+	return hashPHC(h, clearpw, h.SaltLen)
+}
+
+func (h Argon2idHasher) derive(clearpw string, salt []byte) ([]byte, error) {
+	return argon2.IDKey([]byte(clearpw), salt, h.Time, h.Memory, h.Threads,
+		h.KeyLen), nil
+}
+
+func (h Argon2idHasher) encode(salt, dk []byte) PHC {
+	return PHC{ID: Argon2id, Version: argon2.Version, Params: []Param{
+		{"m", strconv.FormatUint(uint64(h.Memory), 10)},
+		{"t", strconv.FormatUint(uint64(h.Time), 10)},
+		{"p", strconv.Itoa(int(h.Threads))},
+	}, Salt: salt, Hash: dk}
+}
+
+// PBKDF2Hasher -- PBKDF2 with HMAC-SHA512 over Iterations rounds.
+//
+//	$pbkdf2-sha512$i=<Iterations>$<salt>$<hash>
+type PBKDF2Hasher struct {
+	Iterations int
+	KeyLen     int
+	SaltLen    int
+}
+
+func (PBKDF2Hasher) Name() string { return PBKDF2SHA512 }
+
+func (h PBKDF2Hasher) Hash(clearpw string) (string, error) {
+	return hashPHC(h, clearpw, h.SaltLen)
+}
+
+func (h PBKDF2Hasher) derive(clearpw string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key([]byte(clearpw), salt, h.Iterations, h.KeyLen,
+		sha512.New), nil
+}
+
+func (h PBKDF2Hasher) encode(salt, dk []byte) PHC {
+	return PHC{ID: PBKDF2SHA512, Params: []Param{
+		{"i", strconv.Itoa(h.Iterations)},
+	}, Salt: salt, Hash: dk}
+}
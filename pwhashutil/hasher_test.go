@@ -0,0 +1,122 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of the Hashers: the legacy HashifyPW digest, and a round trip
+// through Hash and Verify for each algorithm.
+//
+
+package pwhashutil_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+)
+
+func TestHashifyPW(t *testing.T) {
+	const want = "ZEHhWB65gUlzdVwtDQArEyx-KVLzp_aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A-gf7Q=="
+	if got := passhash.HashifyPW("angryMonkey"); got != want {
+		t.Errorf("HashifyPW(angryMonkey) = %q, want %q", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	prefixes := map[string]string{
+		passhash.SHA512:       "$sha512$",
+		passhash.Bcrypt:       "$2a$10$",
+		passhash.Scrypt:       "$scrypt$ln=15,r=8,p=1$",
+		passhash.Argon2id:     "$argon2id$v=19$m=65536,t=1,p=4$",
+		passhash.PBKDF2SHA512: "$pbkdf2-sha512$i=210000$",
+	}
+	for _, name := range passhash.Algorithms() {
+		t.Run(name, func(t *testing.T) {
+			h, err := passhash.NewHasher(strings.ToUpper(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Name() != name {
+				t.Errorf("Name() = %q", h.Name())
+			}
+			encoded, err := h.Hash("angryMonkey")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(encoded, prefixes[name]) {
+				t.Errorf("Hash = %q, want %s...", encoded, prefixes[name])
+			}
+			if again, _ := h.Hash("angryMonkey"); again == encoded {
+				t.Errorf("Hash not salted: %q twice", encoded)
+			}
+			if ok, err := passhash.Verify("angryMonkey", encoded); !ok || err != nil {
+				t.Errorf("Verify(angryMonkey) = %v, %v", ok, err)
+			}
+			if ok, err := passhash.Verify("angryMonkey1", encoded); ok || err != nil {
+				t.Errorf("Verify(angryMonkey1) = %v, %v", ok, err)
+			}
+			if passhash.NeedsRehash(encoded, h) {
+				t.Error("NeedsRehash under the Hasher that made it")
+			}
+			if got := passhash.AlgorithmOf(encoded); got != name {
+				t.Errorf("AlgorithmOf = %q", got)
+			}
+			if got, err := passhash.HasherOf(encoded); err != nil || got != h {
+				t.Errorf("HasherOf = %#v, %v, want %#v", got, err, h)
+			}
+		})
+	}
+	if _, err := passhash.NewHasher("md5"); !errors.Is(err, passhash.ErrUnknownAlgorithm) {
+		t.Errorf("NewHasher(md5): %v", err)
+	}
+}
+
+func TestNewHasherParams(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params map[string]int
+		prefix string // of the hash, "" if the parameters are refused
+	}{
+		{passhash.Bcrypt, map[string]int{"cost": 4}, "$2a$04$"},
+		{passhash.Bcrypt, map[string]int{"cost": 3}, ""},
+		{passhash.Bcrypt, map[string]int{"cost": 17}, ""},
+		{passhash.Scrypt, map[string]int{"ln": 10, "r": 4, "p": 2},
+			"$scrypt$ln=10,r=4,p=2$"},
+		{passhash.Scrypt, map[string]int{"ln": 19, "r": 8}, ""}, // 512 MiB
+		{passhash.Scrypt, map[string]int{"ln": 10, "p": 17}, ""},
+		{passhash.Argon2id, map[string]int{"m": 8192, "t": 2, "p": 1},
+			"$argon2id$v=19$m=8192,t=2,p=1$"},
+		{passhash.Argon2id, map[string]int{"m": 262145}, ""},
+		{passhash.Argon2id, map[string]int{"t": 11}, ""},
+		{passhash.Argon2id, map[string]int{"m": 16, "p": 4}, ""},
+		{passhash.PBKDF2SHA512, map[string]int{"i": 1000},
+			"$pbkdf2-sha512$i=1000$"},
+		{passhash.PBKDF2SHA512, map[string]int{"i": 999}, ""},
+		{passhash.PBKDF2SHA512, map[string]int{"cost": 10}, ""},
+		{passhash.SHA512, map[string]int{"i": 1000}, ""},
+	} {
+		h, err := passhash.NewHasherParams(tc.name, tc.params)
+		if tc.prefix == "" {
+			if !errors.Is(err, passhash.ErrBadParams) {
+				t.Errorf("NewHasherParams(%s, %v): %v, want ErrBadParams",
+					tc.name, tc.params, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewHasherParams(%s, %v): %v", tc.name, tc.params, err)
+			continue
+		}
+		encoded, err := h.Hash("angryMonkey")
+		if err != nil || !strings.HasPrefix(encoded, tc.prefix) {
+			t.Errorf("%s %v: Hash = %q, %v, want %s...", tc.name, tc.params,
+				encoded, err, tc.prefix)
+		}
+		if ok, _ := passhash.Verify("angryMonkey", encoded); !ok {
+			t.Errorf("%s %v: %q does not verify", tc.name, tc.params, encoded)
+		}
+	}
+}
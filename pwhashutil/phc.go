@@ -0,0 +1,148 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// PHC string format encoding and parsing, as described at
+// https://github.com/P-H-C/phc-string-format/blob/master/phc-sf-spec.md
+//
+//    $<id>[$v=<version>][$<param>=<value>(,<param>=<value>)*][$<salt>[$<hash>]]
+//
+// Salt and hash are Base64 encoded with the standard alphabet and no
+// padding.  Example:
+//    $argon2id$v=19$m=65536,t=1,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG
+//
+
+package pwhashutil
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrBadPHC is returned by ParsePHC for strings it cannot make sense of
+var ErrBadPHC = errors.New("pwhashutil: malformed PHC string")
+
+// b64 -- PHC strings use standard Base64 without padding
+var b64 = base64.RawStdEncoding
+
+// Param -- a single name=value pair of a PHC string
+type Param struct {
+	Name  string
+	Value string
+}
+
+// PHC -- the parts of a PHC format hash string
+type PHC struct {
+	ID      string  // algorithm identifier, e.g. "argon2id"
+	Version int     // "v=" value, 0 if absent
+	Params  []Param // algorithm parameters, in encoded order
+	Salt    []byte
+	Hash    []byte
+}
+
+// Get -- value of the named parameter, "" if it is not present
+func (p PHC) Get(name string) string {
+	for _, prm := range p.Params {
+		if prm.Name == name {
+			return prm.Value
+		}
+	}
+	return ""
+}
+
+// Int -- value of the named parameter as an int
+func (p PHC) Int(name string) (int, error) {
+	v := p.Get(name)
+	if v == "" {
+		return 0, fmt.Errorf("%w: missing parameter %q", ErrBadPHC, name)
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: bad value for parameter %q", ErrBadPHC, name)
+	}
+	return n, nil
+}
+
+// String -- the PHC format encoding of p
+func (p PHC) String() string {
+	var sb strings.Builder
+	sb.WriteString("$" + p.ID)
+	if p.Version != 0 {
+		sb.WriteString("$v=" + strconv.Itoa(p.Version))
+	}
+	if len(p.Params) > 0 {
+		sb.WriteString("$")
+		for i, prm := range p.Params {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(prm.Name + "=" + prm.Value)
+		}
+	}
+	if p.Salt != nil {
+		sb.WriteString("$" + b64.EncodeToString(p.Salt))
+		if p.Hash != nil {
+			sb.WriteString("$" + b64.EncodeToString(p.Hash))
+		}
+	}
+	return sb.String()
+}
+
+// ParsePHC -- split a PHC format string back into its parts
+func ParsePHC(s string) (PHC, error) {
+	var p PHC
+	fields := strings.Split(s, "$")
+	if len(fields) < 2 || fields[0] != "" || fields[1] == "" {
+		return p, ErrBadPHC
+	}
+	p.ID = fields[1]
+	fields = fields[2:]
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "v=") {
+		v, err := strconv.Atoi(fields[0][2:])
+		if err != nil {
+			return p, fmt.Errorf("%w: bad version", ErrBadPHC)
+		}
+		p.Version = v
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && strings.Contains(fields[0], "=") {
+		for _, kv := range strings.Split(fields[0], ",") {
+			name, value, ok := strings.Cut(kv, "=")
+			if !ok || name == "" {
+				return p, fmt.Errorf("%w: bad parameter %q", ErrBadPHC, kv)
+			}
+			p.Params = append(p.Params, Param{name, value})
+		}
+		fields = fields[1:]
+	}
+	if len(fields) > 2 {
+		return p, fmt.Errorf("%w: too many fields", ErrBadPHC)
+	}
+	var err error
+	if len(fields) > 0 {
+		if p.Salt, err = b64.DecodeString(fields[0]); err != nil {
+			return p, fmt.Errorf("%w: bad salt encoding", ErrBadPHC)
+		}
+	}
+	if len(fields) > 1 {
+		if p.Hash, err = b64.DecodeString(fields[1]); err != nil {
+			return p, fmt.Errorf("%w: bad hash encoding", ErrBadPHC)
+		}
+	}
+	return p, nil
+}
+
+// newSalt -- n bytes from the system's secure random source
+func newSalt(n int) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Policy on the hashes a server makes or checks for its clients.  When a
+// client may pick the algorithm and cost parameters, it should not be able
+// to downgrade to a fast hash, nor to have the server spend seconds and
+// gigabytes on one password:
+//
+//    policy := pwhashutil.DefaultPolicy(hasher)
+//    h, err := pwhashutil.NewHasherParams(name, params)
+//    if err == nil {
+//        err = policy.Check(h)
+//    }
+//
+
+package pwhashutil
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// ErrPolicy is returned by Policy.Check for an algorithm or cost
+// parameters the policy does not allow
+var ErrPolicy = errors.New("pwhashutil: not allowed by hashing policy")
+
+// Policy -- the algorithms clients may ask for, and per algorithm the
+// highest value of each cost parameter, named as for NewHasherParams
+type Policy struct {
+	Allowed []string
+	Max     map[string]map[string]int
+}
+
+// DefaultPolicy -- the policy of a server hashing with h: every algorithm
+// but the fast sha512, unless h is sha512 itself, with each cost parameter
+// up to four times the work of h or of this package's defaults, whichever
+// is more.  Parallelism and block size are kept to those of h or the
+// defaults.
+func DefaultPolicy(h Hasher) Policy {
+	p := Policy{Max: map[string]map[string]int{}}
+	for _, name := range Algorithms() {
+		if name != SHA512 || h.Name() == SHA512 {
+			p.Allowed = append(p.Allowed, name)
+		}
+		def, _ := NewHasher(name)
+		base := costParams(def)
+		if name == h.Name() {
+			for prm, v := range costParams(h) {
+				base[prm] = max(base[prm], v)
+			}
+		}
+		for prm, v := range base {
+			switch {
+			case prm == "cost" || prm == "ln":
+				base[prm] = v + 2
+			case prm == "m" || prm == "t" || prm == "i":
+				base[prm] = 4 * v
+			}
+		}
+		p.Max[name] = base
+	}
+	return p
+}
+
+// Check -- whether the policy allows hashing with h
+func (p Policy) Check(h Hasher) error {
+	if !slices.Contains(p.Allowed, h.Name()) {
+		return fmt.Errorf("%w: algorithm %s", ErrPolicy, h.Name())
+	}
+	return p.Within(h)
+}
+
+// Within -- whether the cost parameters of h are within the policy's
+// ceilings, whether or not its algorithm is allowed.  For checking the
+// hashes given to Verify.
+func (p Policy) Within(h Hasher) error {
+	params := costParams(h)
+	names := make([]string, 0, len(params))
+	for prm := range params {
+		names = append(names, prm)
+	}
+	sort.Strings(names)
+	for _, prm := range names {
+		hi, ok := p.Max[h.Name()][prm]
+		if ok && params[prm] > hi {
+			return fmt.Errorf("%w: %s %s=%d, above %d", ErrPolicy, h.Name(),
+				prm, params[prm], hi)
+		}
+	}
+	return nil
+}
+
+// costParams -- the cost parameters of h, named as for NewHasherParams
+func costParams(h Hasher) map[string]int {
+	switch hh := h.(type) {
+	case BcryptHasher:
+		return map[string]int{"cost": hh.Cost}
+	case ScryptHasher:
+		ln := 0
+		for n := hh.N; n > 1; n >>= 1 {
+			ln++
+		}
+		return map[string]int{"ln": ln, "r": hh.R, "p": hh.P}
+	case Argon2idHasher:
+		return map[string]int{"m": int(hh.Memory), "t": int(hh.Time),
+			"p": int(hh.Threads)}
+	case PBKDF2Hasher:
+		return map[string]int{"i": hh.Iterations}
+	}
+	return map[string]int{}
+}
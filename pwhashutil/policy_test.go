@@ -0,0 +1,65 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of DefaultPolicy and the checks of a Policy.
+//
+
+package pwhashutil_test
+
+import (
+	"errors"
+	"testing"
+
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+)
+
+func TestPolicy(t *testing.T) {
+	server, _ := passhash.NewHasherParams(passhash.Argon2id,
+		map[string]int{"t": 2})
+	p := passhash.DefaultPolicy(server)
+	for _, tc := range []struct {
+		name   string
+		params map[string]int
+		ok     bool
+	}{
+		{passhash.Argon2id, nil, true},
+		{passhash.Argon2id, map[string]int{"t": 8}, true}, // 4 times the server's
+		{passhash.Argon2id, map[string]int{"t": 9}, false},
+		{passhash.Argon2id, map[string]int{"m": 262144}, true},
+		{passhash.Argon2id, map[string]int{"p": 5}, false},
+		{passhash.Bcrypt, map[string]int{"cost": 12}, true},
+		{passhash.Bcrypt, map[string]int{"cost": 13}, false},
+		{passhash.Scrypt, map[string]int{"ln": 17}, true},
+		{passhash.Scrypt, map[string]int{"ln": 17, "r": 9}, false},
+		{passhash.PBKDF2SHA512, map[string]int{"i": 840000}, true},
+		{passhash.PBKDF2SHA512, map[string]int{"i": 840001}, false},
+		{passhash.SHA512, nil, false},
+	} {
+		h, err := passhash.NewHasherParams(tc.name, tc.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Check(h)
+		if tc.ok != (err == nil) || (err != nil && !errors.Is(err, passhash.ErrPolicy)) {
+			t.Errorf("Check(%s %v) = %v, want ok %v", tc.name, tc.params, err,
+				tc.ok)
+		}
+	}
+	// sha512 hashes may be checked, whether or not they may be made
+	for _, ka := range knownAnswers {
+		h, err := passhash.HasherOf(ka.encoded)
+		if err != nil {
+			t.Fatalf("%s: HasherOf: %v", ka.name, err)
+		}
+		if err := p.Within(h); err != nil {
+			t.Errorf("%s: Within: %v", ka.name, err)
+		}
+	}
+	sha, _ := passhash.NewHasher(passhash.SHA512)
+	if err := passhash.DefaultPolicy(sha).Check(sha); err != nil {
+		t.Errorf("sha512 server refusing sha512: %v", err)
+	}
+}
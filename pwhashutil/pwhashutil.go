@@ -8,6 +8,11 @@
 // string of the password that has been hashed with SHA512 as the hashing 
 // algorithm.
 //
+// HashifyPW is unsalted, so equal passwords always give equal hashes; it
+// is kept for compatibility only.  New code should use a Hasher (see
+// hasher.go), selected by name with NewHasher, which salts every hash with
+// random bytes and returns a self-describing PHC format string.
+//
 package pwhashutil
 
 import (
@@ -15,6 +20,9 @@ import (
 	"encoding/base64"
 )
 
+// HashifyPW -- unsalted SHA512 of clearpw, Base64 (URL alphabet) encoded
+//
+// Deprecated: use NewHasher(DefaultAlgorithm) instead.
 func HashifyPW(clearpw string) (pw string) {
 	sha_512 := sha512.New()
 	sha_512.Write([]byte(clearpw))
@@ -0,0 +1,222 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Checking a candidate password against a previously encoded hash.
+// Every format this package produces is understood: the PHC strings of
+// the Hasher implementations, bcrypt strings, and the bare Base64 output
+// of the legacy HashifyPW.
+//
+//    ok, err := pwhashutil.Verify("angryMonkey", stored)
+//    if ok && pwhashutil.NeedsRehash(stored, policy) {
+//        stored, err = policy.Hash("angryMonkey")
+//    }
+//
+
+package pwhashutil
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// upper bounds on the cost parameters Verify will accept from an encoded
+// hash, and NewHasherParams from its caller, so a hostile hash string
+// cannot tie up the CPU for long or exhaust memory.  They sit well above
+// the default costs, so a hash at the limit takes some seconds of one
+// core and 256 MiB at most; on a current x86-64 core about 8s for scrypt
+// at ln=20 and p=16, 5s for bcrypt cost 16, 3s for argon2id t=10 at
+// 256 MiB and 2s for PBKDF2 at 2000000 rounds.  The server's cap on
+// concurrent hashing bounds how many such run at once.
+const (
+	maxKeyLen     = 1024
+	maxBcryptCost = 16
+	maxScryptLogN = 20
+	maxScryptMem  = 256 << 20 // bytes, 128 * 2^ln * r
+	maxScryptP    = 16
+	maxArgonMem   = 256 << 10 // KiB, 256 MiB
+	maxArgonTime  = 10
+	maxPBKDF2Iter = 2000000
+)
+
+// scryptInRange -- whether scrypt parameters are within the bounds above
+func scryptInRange(ln, r, p int) bool {
+	return ln >= 1 && ln <= maxScryptLogN && r >= 1 && p >= 1 &&
+		p <= maxScryptP && 128*r <= maxScryptMem>>ln
+}
+
+// Verify -- report whether candidate is the password that encoded was
+// made from.  The comparison is done in constant time.  An error is only
+// returned when encoded cannot be understood.
+func Verify(candidate, encoded string) (bool, error) {
+	if !strings.HasPrefix(encoded, "$") {
+		// legacy unsalted HashifyPW output
+		legacy := HashifyPW(candidate)
+		return subtle.ConstantTimeCompare([]byte(legacy), []byte(encoded)) == 1, nil
+	}
+	if isBcrypt(encoded) {
+		if cost, err := bcrypt.Cost([]byte(encoded)); err == nil &&
+			cost > maxBcryptCost {
+			return false, fmt.Errorf("%w: bcrypt cost out of range", ErrBadPHC)
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(candidate))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	p, err := ParsePHC(encoded)
+	if err != nil {
+		return false, err
+	}
+	h, err := decodeKDF(p)
+	if err != nil {
+		return false, err
+	}
+	dk, err := h.derive(candidate, p.Salt)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(dk, p.Hash) == 1, nil
+}
+
+// NeedsRehash -- report whether encoded was made with an algorithm or cost
+// parameters other than those of policy, the Hasher new hashes are made
+// with.  Hashes that cannot be understood, and legacy HashifyPW output,
+// always need rehashing.  Only meaningful once the password is known to
+// match, as the password is needed to make the new hash.
+func NeedsRehash(encoded string, policy Hasher) bool {
+	if !strings.HasPrefix(encoded, "$") {
+		return true
+	}
+	if isBcrypt(encoded) {
+		bp, ok := policy.(BcryptHasher)
+		if !ok {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != bp.Cost
+	}
+	p, err := ParsePHC(encoded)
+	if err != nil {
+		return true
+	}
+	h, err := decodeKDF(p)
+	if err != nil {
+		return true
+	}
+	// the decoded Hasher carries the key and salt lengths as well as the
+	// cost parameters, so a plain comparison covers every setting
+	return Hasher(h) != policy
+}
+
+// HasherOf -- the Hasher, with its cost parameters, that made encoded.
+// Legacy HashifyPW output is taken as made by sha512.  For checking a
+// hash against a Policy before spending the work of Verify on it.
+func HasherOf(encoded string) (Hasher, error) {
+	switch {
+	case !strings.HasPrefix(encoded, "$"):
+		return SHA512Hasher{}, nil
+	case isBcrypt(encoded):
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadPHC, err)
+		}
+		return BcryptHasher{Cost: cost}, nil
+	}
+	p, err := ParsePHC(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return decodeKDF(p)
+}
+
+// AlgorithmOf -- name of the algorithm that made encoded, as accepted by
+// NewHasher, or "" if it is not a PHC or bcrypt string
+func AlgorithmOf(encoded string) string {
+	switch {
+	case isBcrypt(encoded):
+		return Bcrypt
+	case strings.HasPrefix(encoded, "$"):
+		id, _, _ := strings.Cut(encoded[1:], "$")
+		return id
+	}
+	return ""
+}
+
+// isBcrypt -- bcrypt strings start "$2a$", "$2b$" or "$2y$"
+func isBcrypt(encoded string) bool {
+	return len(encoded) > 4 && encoded[:2] == "$2" && encoded[3] == '$'
+}
+
+// decodeKDF -- the Hasher, with the cost parameters recorded in p, that
+// produced p
+func decodeKDF(p PHC) (kdf, error) {
+	if len(p.Salt) == 0 || len(p.Hash) == 0 || len(p.Hash) > maxKeyLen {
+		return nil, fmt.Errorf("%w: missing salt or hash", ErrBadPHC)
+	}
+	switch p.ID {
+	case SHA512:
+		return SHA512Hasher{SaltLen: len(p.Salt)}, nil
+	case Scrypt:
+		ln, err := p.Int("ln")
+		if err != nil {
+			return nil, err
+		}
+		r, err := p.Int("r")
+		if err != nil {
+			return nil, err
+		}
+		par, err := p.Int("p")
+		if err != nil {
+			return nil, err
+		}
+		if !scryptInRange(ln, r, par) {
+			return nil, fmt.Errorf("%w: scrypt parameters out of range", ErrBadPHC)
+		}
+		return ScryptHasher{N: 1 << ln, R: r, P: par, KeyLen: len(p.Hash),
+			SaltLen: len(p.Salt)}, nil
+	case Argon2id:
+		if p.Version != argon2.Version {
+			return nil, fmt.Errorf("%w: unsupported argon2 version %d",
+				ErrBadPHC, p.Version)
+		}
+		m, err := p.Int("m")
+		if err != nil {
+			return nil, err
+		}
+		t, err := p.Int("t")
+		if err != nil {
+			return nil, err
+		}
+		par, err := p.Int("p")
+		if err != nil {
+			return nil, err
+		}
+		if m < 8*par || m > maxArgonMem || t < 1 || t > maxArgonTime ||
+			par < 1 || par > 255 {
+			return nil, fmt.Errorf("%w: argon2id parameters out of range", ErrBadPHC)
+		}
+		return Argon2idHasher{Time: uint32(t), Memory: uint32(m),
+			Threads: uint8(par), KeyLen: uint32(len(p.Hash)),
+			SaltLen: len(p.Salt)}, nil
+	case PBKDF2SHA512:
+		i, err := p.Int("i")
+		if err != nil {
+			return nil, err
+		}
+		if i < 1 || i > maxPBKDF2Iter {
+			return nil, fmt.Errorf("%w: pbkdf2 iterations out of range", ErrBadPHC)
+		}
+		return PBKDF2Hasher{Iterations: i, KeyLen: len(p.Hash),
+			SaltLen: len(p.Salt)}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, p.ID)
+}
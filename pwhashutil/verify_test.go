@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests of Verify against known answers, hashes cross-checked against
+// Python's hashlib and the crypt_blowfish "U*U" vector, of its limits on
+// hostile hashes, and of NeedsRehash.
+//
+
+package pwhashutil_test
+
+import (
+	"testing"
+
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+)
+
+// knownAnswers -- encoded hashes with the passwords they were made from
+var knownAnswers = []struct{ name, encoded, password string }{
+	{"legacy sha512",
+		"ZEHhWB65gUlzdVwtDQArEyx-KVLzp_aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A-gf7Q==",
+		"angryMonkey"},
+	{passhash.SHA512,
+		"$sha512$kTiChSdXNFodsw0ff5WZaQ$MAnJ6zHqs6kA+CSwkOIpvLeRD2f+2FlDiIIGk2X4IvYOFrNPpzVVKmu3+c3KAoLGQ3MTnP7vATbksezRMwU5oA",
+		"angryMonkey"},
+	{passhash.PBKDF2SHA512,
+		"$pbkdf2-sha512$i=210000$zEW2jP0Oy12eUcDsiI5MRQ$JsGO6HbKr8se7RG64U+SqxwjuOFNBMaE4iwHmoNoutzIVPizKtUnVYkJmD8xqrnhrnL+2x607+wQUpRmp3ReiQ",
+		"angryMonkey"},
+	{passhash.Scrypt,
+		"$scrypt$ln=15,r=8,p=1$k3gEGddEtQPWf/uQCFE2Jg$wxdmJpnDI92OdwTk6IVU/xEqbuNGoCQRdGQ6ykr56RI",
+		"angryMonkey"},
+	{passhash.Bcrypt,
+		"$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"U*U"},
+	{passhash.Argon2id,
+		"$argon2id$v=19$m=65536,t=1,p=4$MHeMZVm6LBOCSvMkorTavA$QyUC5xof+RizqCCsOTTb6CX2SlJunEJEJkAW4UUYfgs",
+		"angryMonkey"},
+}
+
+func TestVerifyKnownAnswers(t *testing.T) {
+	for _, ka := range knownAnswers {
+		if ok, err := passhash.Verify(ka.password, ka.encoded); !ok || err != nil {
+			t.Errorf("%s: Verify(%q) = %v, %v", ka.name, ka.password, ok, err)
+		}
+		if ok, err := passhash.Verify(ka.password+"1", ka.encoded); ok || err != nil {
+			t.Errorf("%s: Verify(%q) = %v, %v", ka.name, ka.password+"1",
+				ok, err)
+		}
+	}
+}
+
+func TestVerifyBadHashes(t *testing.T) {
+	for what, encoded := range map[string]string{
+		"unknown algorithm": "$md5$c2FsdA$aGFzaA",
+		"no salt":           "$sha512$$MAnJ6zHqs6kA",
+		"not base64":        "$sha512$!!$MAnJ6zHqs6kA",
+		"no parameters":     "$scrypt$k3gEGddEtQPWf/uQCFE2Jg$wxdmJpnDI92OdwTk6IVU/xEqbuNGoCQRdGQ6ykr56RI",
+		"argon2 version":    "$argon2id$v=16$m=65536,t=1,p=4$MHeMZVm6LBOCSvMkorTavA$QyUC5xof+RizqCCsOTTb6CX2SlJunEJEJkAW4UUYfgs",
+		// beyond the limits on hostile hashes
+		"bcrypt cost":      "$2a$17$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"scrypt memory":    "$scrypt$ln=19,r=8,p=1$k3gEGddEtQPWf/uQCFE2Jg$wxdmJpnDI92OdwTk6IVU/xEqbuNGoCQRdGQ6ykr56RI",
+		"scrypt p":         "$scrypt$ln=10,r=8,p=17$k3gEGddEtQPWf/uQCFE2Jg$wxdmJpnDI92OdwTk6IVU/xEqbuNGoCQRdGQ6ykr56RI",
+		"argon2 memory":    "$argon2id$v=19$m=1048576,t=1,p=4$MHeMZVm6LBOCSvMkorTavA$QyUC5xof+RizqCCsOTTb6CX2SlJunEJEJkAW4UUYfgs",
+		"argon2 time":      "$argon2id$v=19$m=65536,t=11,p=4$MHeMZVm6LBOCSvMkorTavA$QyUC5xof+RizqCCsOTTb6CX2SlJunEJEJkAW4UUYfgs",
+		"pbkdf2 rounds":    "$pbkdf2-sha512$i=2000001$zEW2jP0Oy12eUcDsiI5MRQ$JsGO6HbKr8se7RG64U+SqxwjuOFNBMaE4iwHmoNoutzIVPizKtUnVYkJmD8xqrnhrnL+2x607+wQUpRmp3ReiQ",
+		"pbkdf2 no rounds": "$pbkdf2-sha512$i=0$zEW2jP0Oy12eUcDsiI5MRQ$JsGO6HbKr8se7RG64U+SqxwjuOFNBMaE4iwHmoNoutzIVPizKtUnVYkJmD8xqrnhrnL+2x607+wQUpRmp3ReiQ",
+	} {
+		if ok, err := passhash.Verify("angryMonkey", encoded); ok || err == nil {
+			t.Errorf("%s: Verify(%q) = %v, %v, want an error", what, encoded,
+				ok, err)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon, _ := passhash.NewHasher(passhash.Argon2id)
+	stronger, _ := passhash.NewHasherParams(passhash.Argon2id,
+		map[string]int{"t": 2})
+	for _, ka := range knownAnswers {
+		want := ka.name != passhash.Argon2id
+		if got := passhash.NeedsRehash(ka.encoded, argon); got != want {
+			t.Errorf("%s: NeedsRehash under argon2id defaults = %v", ka.name,
+				got)
+		}
+		if !passhash.NeedsRehash(ka.encoded, stronger) {
+			t.Errorf("%s: no NeedsRehash under argon2id t=2", ka.name)
+		}
+	}
+	bcrypt5 := passhash.BcryptHasher{Cost: 5}
+	if passhash.NeedsRehash(knownAnswers[4].encoded, bcrypt5) {
+		t.Error("bcrypt cost 5: NeedsRehash under bcrypt cost 5")
+	}
+	if !passhash.NeedsRehash("$nosuch$x$y", argon) {
+		t.Error("no NeedsRehash of a bad hash")
+	}
+}
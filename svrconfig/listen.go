@@ -0,0 +1,33 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Listening on the -listen address.  A bare port number listens on
+// localhost only, as the servers always have; ":8088" or "0.0.0.0:8088"
+// on every interface, "unix:/run/hashpw.sock" on a Unix domain socket.
+//
+
+package svrconfig
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// Listen -- listen on addr, in the syntax of the -listen flag
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// a socket left behind by an earlier server would be in the way
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	if !strings.Contains(addr, ":") {
+		addr = "localhost:" + addr
+	}
+	return net.Listen("tcp", addr)
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Logging.  The servers log with log/slog to stderr, in logfmt
+// (-logformat text) or as JSON lines (-logformat json), dropping messages
+// below -loglevel.  LogRequests gives every request an ID and logs one
+// line per request once it is served:
+//
+//    time=... level=INFO msg=request request_id=5f1c0e2a9b3d7e41 method=POST
+//        path=/hash status=200 latency=1.2ms bytes=37 remote=127.0.0.1:50312
+//
+// Passwords are never logged: the value of any attribute named like a
+// password or other secret (see Sensitive), and such fields of logged
+// forms and headers, are replaced by "[REDACTED]" whatever the format.
+//
+
+package svrconfig
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Redacted -- logged in place of a secret
+const Redacted = "[REDACTED]"
+
+// log formats, of -logformat
+var logFormats = []string{"text", "json"}
+
+// log levels, of -loglevel
+var logLevels = map[string]slog.Level{"debug": slog.LevelDebug,
+	"info": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError}
+
+// secret names, or parts of names, of logged attributes and fields
+var (
+	secretNames = map[string]bool{"pw": true, "pass": true, "token": true,
+		"api_key": true, "x_api_key": true, "authorization": true}
+	secretParts = []string{"password", "passwd", "passphrase", "secret"}
+)
+
+// Sensitive -- whether name is that of a password or other secret, so
+// its value must never be logged
+func Sensitive(name string) bool {
+	name = strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+	if secretNames[name] {
+		return true
+	}
+	for _, part := range secretParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactAttr -- slog.HandlerOptions.ReplaceAttr hiding the values of
+// secrets, as in the loggers of the servers
+func RedactAttr(groups []string, a slog.Attr) slog.Attr {
+	if Sensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	if a.Value.Kind() != slog.KindAny {
+		return a
+	}
+	switch v := a.Value.Any().(type) {
+	case url.Values:
+		return slog.Any(a.Key, redactValues(v))
+	case http.Header:
+		return slog.Any(a.Key, http.Header(redactValues(url.Values(v))))
+	}
+	return a
+}
+
+// redactValues -- copy of form or header values with the secrets hidden
+func redactValues(values map[string][]string) url.Values {
+	copied := url.Values{}
+	for name, vs := range values {
+		if Sensitive(name) {
+			vs = []string{Redacted}
+		}
+		copied[name] = vs
+	}
+	return copied
+}
+
+// checkLogging -- validate the log format and level, and make a logger of
+// them the default
+func (c *Config) checkLogging() error {
+	level, ok := logLevels[c.LogLevel]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", c.LogLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: RedactAttr}
+	switch c.LogFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+	return nil
+}
+
+// context keys of the logger and ID of a request
+type (
+	loggerKey    struct{}
+	requestIDKey struct{}
+)
+
+// validRequestID -- form of the request IDs taken from clients
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// newRequestID -- the X-Request-ID req came with, else a new random one
+func newRequestID(req *http.Request) string {
+	if id := req.Header.Get("X-Request-ID"); validRequestID.MatchString(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestID -- the ID LogRequests gave req, "" if none
+func RequestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestLogger -- the logger of req, adding its request ID to what is
+// logged.  The default logger if req did not come through LogRequests.
+func RequestLogger(req *http.Request) *slog.Logger {
+	if l, ok := req.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// LogRequests -- handler wrapping h, giving each request an ID, echoed in
+// the X-Request-ID response header, and logging it to logger once served
+func LogRequests(logger *slog.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := newRequestID(req)
+		rw.Header().Set("X-Request-ID", id)
+		logger := logger.With("request_id", id)
+		rec := &loggedResponse{ResponseWriter: rw, status: http.StatusOK}
+		ctx := context.WithValue(req.Context(), loggerKey{}, logger)
+		ctx = context.WithValue(ctx, requestIDKey{}, id)
+		h.ServeHTTP(rec, req.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{slog.String("method", req.Method),
+			slog.String("path", req.URL.Path), slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", rec.bytes), slog.String("remote", req.RemoteAddr)}
+		if client := ClientIdentity(req); client != "" {
+			attrs = append(attrs, slog.String("client", client))
+		}
+		logger.LogAttrs(req.Context(), level, "request", attrs...)
+	})
+}
+
+// loggedResponse -- ResponseWriter noting the status and size of the
+// response for LogRequests
+type loggedResponse struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+	wrote  bool
+}
+
+func (r *loggedResponse) WriteHeader(status int) {
+	if !r.wrote {
+		r.status, r.wrote = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *loggedResponse) Write(p []byte) (int, error) {
+	r.wrote = true
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += n
+	return n, err
+}
+
+func (r *loggedResponse) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap -- the wrapped ResponseWriter, for http.ResponseController
+func (r *loggedResponse) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Tests that secrets never reach the log, whatever the format and
+// however they are logged.
+//
+
+package svrconfig_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stevewahl/GoTest/hashserver"
+	"github.com/stevewahl/GoTest/svrconfig"
+)
+
+// secret -- the password or credential logged by the tests
+const secret = "angryMonkey-s3cret"
+
+// newLogger -- a debug level logger of format, writing to buf, with the
+// servers' RedactAttr
+func newLogger(t *testing.T, format string, buf *bytes.Buffer) *slog.Logger {
+	t.Helper()
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug,
+		ReplaceAttr: svrconfig.RedactAttr}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(buf, opts))
+	case "json":
+		return slog.New(slog.NewJSONHandler(buf, opts))
+	}
+	t.Fatalf("unknown log format %q", format)
+	return nil
+}
+
+// checkLog -- fail unless buf has been logged to without secret
+func checkLog(t *testing.T, buf *bytes.Buffer) {
+	t.Helper()
+	if buf.Len() == 0 {
+		t.Fatal("nothing logged")
+	}
+	if strings.Contains(buf.String(), secret) {
+		t.Errorf("secret logged:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), svrconfig.Redacted) {
+		t.Errorf("no %s in the log:\n%s", svrconfig.Redacted, buf.String())
+	}
+}
+
+func TestSensitive(t *testing.T) {
+	for name, want := range map[string]bool{
+		"password": true, "Password": true, "new_password": true,
+		"pw": true, "Authorization": true, "X-API-Key": true,
+		"token": true, "client_secret": true, "passphrase": true,
+		"key": false, "algorithm": false, "path": false, "pwhash": false,
+	} {
+		if got := svrconfig.Sensitive(name); got != want {
+			t.Errorf("Sensitive(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRedactAttr(t *testing.T) {
+	attrs := map[string][]any{
+		"password":      {"password", secret},
+		"pw":            {"pw", secret},
+		"Authorization": {"Authorization", "Bearer " + secret},
+		"X-API-Key":     {"X-API-Key", secret},
+		"group":         {slog.Group("req", "password", secret)},
+		"url.Values": {"form", url.Values{"password": {secret},
+			"key": {"9b2e0f4c"}}},
+		"http.Header": {"header", http.Header{
+			"Authorization": {"Bearer " + secret},
+			"X-Api-Key":     {secret}, "Accept": {"application/json"}}},
+	}
+	for _, format := range []string{"text", "json"} {
+		for name, args := range attrs {
+			t.Run(format+"/"+name, func(t *testing.T) {
+				var buf bytes.Buffer
+				newLogger(t, format, &buf).Info("logged", args...)
+				checkLog(t, &buf)
+			})
+		}
+	}
+}
+
+func TestRedactAttrKeepsOthers(t *testing.T) {
+	var buf bytes.Buffer
+	newLogger(t, "json", &buf).Info("logged",
+		"form", url.Values{"password": {secret}, "key": {"9b2e0f4c"}})
+	if !strings.Contains(buf.String(), "9b2e0f4c") {
+		t.Errorf("non-secret form field not logged:\n%s", buf.String())
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := newLogger(t, format, &buf)
+			// a careless handler, logging all it was sent
+			h := svrconfig.LogRequests(logger, http.HandlerFunc(
+				func(rw http.ResponseWriter, req *http.Request) {
+					req.ParseForm()
+					svrconfig.RequestLogger(req).Debug("got",
+						"form", req.Form, "header", req.Header)
+				}))
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			req, _ := http.NewRequest("POST", srv.URL+"/hash",
+				strings.NewReader(url.Values{"password": {secret}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Authorization", "Bearer "+secret)
+			req.Header.Set("X-Request-ID", "test-1")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := resp.Header.Get("X-Request-ID"); got != "test-1" {
+				t.Errorf("X-Request-ID %q, want test-1", got)
+			}
+			checkLog(t, &buf)
+			if !strings.Contains(buf.String(), "test-1") {
+				t.Errorf("request ID not logged:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestLogRequestsHashServer(t *testing.T) {
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			hs, err := hashserver.New(
+				hashserver.WithLogger(newLogger(t, format, &buf)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(hs)
+			for _, body := range []struct{ ctype, data string }{
+				{"application/x-www-form-urlencoded",
+					url.Values{"password": {secret}}.Encode()},
+				{"application/json", `{"password": "` + secret +
+					`", "algorithm": "pbkdf2-sha512", "params": {"i": 1000}}`},
+				{"application/json",
+					`{"password": "` + secret + `", "algorithm": "md5"}`},
+			} {
+				resp, err := http.Post(srv.URL+"/hash", body.ctype,
+					strings.NewReader(body.data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			// nothing more is logged once the hash jobs are done
+			srv.Close()
+			if err := hs.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(buf.String(), secret) {
+				t.Errorf("password logged:\n%s", buf.String())
+			}
+			if !strings.Contains(buf.String(), "path=/hash") &&
+				!strings.Contains(buf.String(), `"path":"/hash"`) {
+				t.Errorf("POST /hash not logged:\n%s", buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Graceful shutdown, the same for every server.  A PUT to /shutdown,
+// SIGINT or SIGTERM stop the server taking new work.  The listener is
+// closed and in-flight requests are finished, then the server's own
+// outstanding work, such as queued hash jobs, all within the -drain
+// timeout.  The exit status is 0 if all of that was done in time, 1 if
+// not:
+//
+//    sd := svrconfig.NewShutdownFlag()   // sd.Request() on PUT /shutdown
+//    os.Exit(svrconfig.Serve(srv, ln, sd, cfg.Drain))
+//
+
+package svrconfig
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Drainer -- the work of a server besides its http requests, drained once
+// they are done.  A hashserver.Server is one.
+type Drainer interface {
+	// ShutdownRequested is closed once a shutdown has been asked for
+	ShutdownRequested() <-chan struct{}
+	// Shutdown stops new work and waits, until ctx is done, for the rest
+	Shutdown(ctx context.Context) error
+}
+
+// ShutdownFlag -- Drainer of a server with no work besides its requests,
+// whose handlers check Closing before taking a new password
+type ShutdownFlag struct {
+	once    sync.Once
+	closing atomic.Bool
+	ch      chan struct{} // closed once closing is set
+}
+
+// NewShutdownFlag -- a ShutdownFlag not yet requested
+func NewShutdownFlag() *ShutdownFlag {
+	return &ShutdownFlag{ch: make(chan struct{})}
+}
+
+// Request -- stop taking new passwords and start the server's shutdown.
+// Safe to call more than once.
+func (sd *ShutdownFlag) Request() {
+	sd.once.Do(func() {
+		sd.closing.Store(true)
+		close(sd.ch)
+	})
+}
+
+// Closing -- whether new passwords are turned away
+func (sd *ShutdownFlag) Closing() bool {
+	return sd.closing.Load()
+}
+
+// ShutdownRequested -- closed once Request has been called
+func (sd *ShutdownFlag) ShutdownRequested() <-chan struct{} {
+	return sd.ch
+}
+
+// Shutdown -- Request, there being nothing else to wait for
+func (sd *ShutdownFlag) Shutdown(ctx context.Context) error {
+	sd.Request()
+	return nil
+}
+
+// Serve -- run srv on ln until d asks for a shutdown, or SIGINT or SIGTERM
+// arrive, then drain srv and d, allowing at most drain for the whole of
+// it.  Should serving fail, d is still drained.  Returns the exit status.
+func Serve(srv *http.Server, ln net.Listener, d Drainer,
+	drain time.Duration) int {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(ln) }()
+	status := 0
+	select {
+	case err := <-errs:
+		slog.Error("http server", "err", err)
+		status = 1
+	case sig := <-sigs:
+		slog.Info("password server received signal", "signal", sig.String())
+	case <-d.ShutdownRequested():
+	}
+	slog.Info("password server shutting down", "drain", drain)
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	// no new connections; wait for the requests being handled
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("in-flight requests not finished", "err", err)
+		status = 1
+	}
+	// no handler can start any more work, let d finish what it has
+	if err := d.Shutdown(ctx); err != nil {
+		slog.Error("outstanding work not finished", "err", err)
+		status = 1
+	}
+	return status
+}
@@ -0,0 +1,401 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// Settings of the http password hashing servers.  Every setting is a
+// command line flag; the same names may be given in a JSON config file
+// (-config, or $HASHPW_CONFIG) and as environment variables HASHPW_<NAME>.
+// The command line wins over the environment, which wins over the file:
+//
+//    $ cat /etc/hashpw.json
+//    {"listen": "0.0.0.0:8088", "algorithm": "scrypt",
+//     "params": {"ln": 16}, "store": "sqlite:/var/lib/hashpw/hashes.db",
+//     "workers": 8, "drain": "1m", "loglevel": "warn", "logformat": "json"}
+//    $ HASHPW_WORKERS=4 ./httpHashPWsvr_no6 -config /etc/hashpw.json -print-config
+//
+// For compatibility the old positional <port> [algorithm [store]]
+// arguments are still accepted, as if given by flag.  For https see tls.go,
+// for logging logging.go.
+//
+//    cfg := svrconfig.New(flag.CommandLine, svrconfig.Drain|svrconfig.Store)
+//    flag.Parse()
+//    if err := cfg.Load(flag.Args()); err != nil { ... }
+//
+
+package svrconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/stevewahl/GoTest/hashstore"
+	passhash "github.com/stevewahl/GoTest/pwhashutil"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix -- prefix of the environment variables overriding settings
+const EnvPrefix = "HASHPW_"
+
+// Feature -- a group of settings only some servers have
+type Feature int
+
+const (
+	Drain  Feature = 1 << iota // -drain, for servers shutting down gracefully
+	Store                      // -store, for servers keeping hashed passwords
+	Jobs                       // -workers, -queue, -jobtimeout, -statusttl
+	Auth                       // -authfile, for servers checking credentials
+	Limits                     // -ratelimit, -burst, -maxhashing
+	Audit                      // -auditlog, -auditmaxsize, -auditkeep
+	Policy                     // -allow, -maxparams, for client chosen costs
+)
+
+// Config -- the effective settings of a server
+type Config struct {
+	Listen     string        // [host]:port, a bare port on localhost, or unix:<path>
+	Algorithm  string        // password hashing algorithm
+	Params     Params        // algorithm cost parameters
+	Store      string        // hashstore.Open spec
+	KeyScheme  string        // form of the keys of stored hashes
+	Workers    int           // hash worker goroutines
+	Queue      int           // hash jobs that may wait for a worker
+	Drain      time.Duration // time allowed to drain on shutdown
+	JobTimeout time.Duration // expiry of hash jobs waiting for a worker
+	StatusTTL  time.Duration // how long a finished job's status is kept
+	LogLevel   string        // one of debug, info, warn, error
+	LogFormat  string        // text (logfmt) or json
+	AuthFile   string        // credentials of the clients allowed in
+	RateLimit  float64       // requests per second per client, 0 for no limit
+	Burst      int           // most requests a client may make at once
+	MaxHashing int           // most passwords hashed or verified at once
+	AuditLog   string        // audit log file, "" for none
+	AuditMax   int           // megabytes the audit log may grow to before rotating
+	AuditKeep  int           // rotated audit log files kept
+	Allow      string        // algorithms clients may ask for, "" for the default
+	MaxParams  Params        // highest client cost parameters, as algorithm.name
+
+	TLSCert     string        // server certificate PEM file, for https
+	TLSKey      string        // its private key PEM file
+	TLSClientCA string        // CA certificates of clients, for mutual TLS
+	TLSReload   time.Duration // how often to look for changed TLS files
+
+	file        string        // -config
+	printConfig bool          // -print-config
+	fs          *flag.FlagSet // the flags of the settings
+	positional  []string      // flag names of the positional arguments
+}
+
+// New -- Config with the default settings of a server having features,
+// its settings registered as flags of fs
+func New(fs *flag.FlagSet, features Feature) *Config {
+	c := &Config{fs: fs, positional: []string{"listen", "algorithm"}}
+	fs.StringVar(&c.Listen, "listen", "",
+		"address to listen on: [host]:port, port (on localhost) or unix:<path>")
+	fs.StringVar(&c.Algorithm, "algorithm", passhash.DefaultAlgorithm,
+		"password hashing algorithm, one of "+
+			strings.Join(passhash.Algorithms(), ", "))
+	c.Params = Params{}
+	fs.Var(c.Params, "params",
+		"algorithm cost parameters as name=value,..., e.g. t=2,m=65536 for argon2id")
+	fs.StringVar(&c.LogLevel, "loglevel", "info",
+		"least severe messages logged, one of debug, info, warn, error")
+	fs.StringVar(&c.LogFormat, "logformat", "text",
+		"format of the log, one of "+strings.Join(logFormats, ", ")+
+			" (text being logfmt)")
+	if features&Store != 0 {
+		fs.StringVar(&c.Store, "store", "memory",
+			"hashed password store: memory, file:<path> or sqlite:<path>")
+		c.positional = append(c.positional, "store")
+		fs.StringVar(&c.KeyScheme, "keyscheme", hashstore.DefaultKeyScheme,
+			"form of the random keys of stored hashes, one of "+
+				strings.Join(hashstore.KeySchemes(), ", "))
+	}
+	if features&Jobs != 0 {
+		fs.IntVar(&c.Workers, "workers", runtime.NumCPU(),
+			"number of workers hashing passwords")
+		fs.IntVar(&c.Queue, "queue", 100,
+			"number of hash jobs that may wait for a worker")
+		fs.DurationVar(&c.JobTimeout, "jobtimeout", time.Minute,
+			"expire hash jobs waiting longer than this for a worker, 0 for never")
+		fs.DurationVar(&c.StatusTTL, "statusttl", 10*time.Minute,
+			"how long the status of a finished hash job is kept")
+	}
+	if features&Auth != 0 {
+		fs.StringVar(&c.AuthFile, "authfile", "",
+			"JSON file of the API keys, tokens and client identities allowed in, with their roles")
+	}
+	if features&Limits != 0 {
+		fs.Float64Var(&c.RateLimit, "ratelimit", 0,
+			"hash and verify requests a second allowed each client, 0 for no limit")
+		fs.IntVar(&c.Burst, "burst", 10,
+			"hash and verify requests a client may make at once under -ratelimit")
+		fs.IntVar(&c.MaxHashing, "maxhashing", runtime.NumCPU(),
+			"most passwords hashed or verified at once")
+	}
+	if features&Audit != 0 {
+		fs.StringVar(&c.AuditLog, "auditlog", "",
+			"hash-chained audit log file of security relevant events, none if empty")
+		fs.IntVar(&c.AuditMax, "auditmaxsize", 100,
+			"megabytes the audit log may grow to before being rotated, 0 for no limit")
+		fs.IntVar(&c.AuditKeep, "auditkeep", 10,
+			"number of rotated audit log files kept")
+	}
+	if features&Policy != 0 {
+		fs.StringVar(&c.Allow, "allow", "",
+			"algorithms clients may ask for, comma separated (default all but sha512)")
+		c.MaxParams = Params{}
+		fs.Var(c.MaxParams, "maxparams",
+			"highest cost parameters clients may ask for, as algorithm.name=value,...,"+
+				" e.g. argon2id.m=131072 (default 4 times the work of -params)")
+	}
+	if features&Drain != 0 {
+		fs.DurationVar(&c.Drain, "drain", 30*time.Second,
+			"on shutdown, time allowed to finish outstanding work")
+	}
+	fs.StringVar(&c.TLSCert, "tlscert", "",
+		"server certificate PEM file, to serve https")
+	fs.StringVar(&c.TLSKey, "tlskey", "", "private key PEM file of -tlscert")
+	fs.StringVar(&c.TLSClientCA, "tlsclientca", "",
+		"CA certificates PEM file, to require client certificates signed by them")
+	fs.DurationVar(&c.TLSReload, "tlsreload", 30*time.Second,
+		"how often to reload the TLS files if changed, 0 for only on SIGHUP")
+	fs.StringVar(&c.file, "config", "",
+		"JSON file of settings, keyed by flag name (env "+EnvPrefix+"CONFIG)")
+	fs.BoolVar(&c.printConfig, "print-config", false,
+		"print the effective settings as JSON and exit")
+	return c
+}
+
+// settingFlag -- whether name is a flag holding a setting, one that may
+// be given in the config file or the environment
+func settingFlag(name string) bool {
+	return name != "config" && name != "print-config"
+}
+
+// envName -- environment variable of flag name
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Load -- settle the settings once the flags have been parsed: the
+// config file, then the environment, then the command line flags and
+// positional args.  The log format and level are applied.
+func (c *Config) Load(args []string) error {
+	given := map[string]string{}
+	c.fs.Visit(func(f *flag.Flag) { given[f.Name] = f.Value.String() })
+	if len(args) > len(c.positional) {
+		return fmt.Errorf("too many arguments: %s", strings.Join(args, " "))
+	}
+	for i, arg := range args {
+		given[c.positional[i]] = arg
+	}
+	if c.file == "" {
+		c.file = os.Getenv(envName("config"))
+	}
+	if c.file != "" {
+		if err := c.loadFile(c.file); err != nil {
+			return err
+		}
+	}
+	var err error
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && err == nil &&
+			settingFlag(f.Name) {
+			if err = f.Value.Set(v); err != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for name, v := range given {
+		if err := c.fs.Set(name, v); err != nil {
+			return fmt.Errorf("-%s: %v", name, err)
+		}
+	}
+	return c.check()
+}
+
+// loadFile -- set the flags named in the JSON config file path
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for name, raw := range settings {
+		if c.fs.Lookup(name) == nil || !settingFlag(name) {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		v, err := flagValue(raw)
+		if err == nil {
+			err = c.fs.Set(name, v)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// flagValue -- the command line spelling of a JSON config file value
+func flagValue(raw json.RawMessage) (string, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+	var params map[string]int
+	if json.Unmarshal(raw, &params) == nil {
+		return Params(params).String(), nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return "", errors.New("expecting a string, number or object of numbers")
+	}
+	return n.String(), nil
+}
+
+// check -- validate the settings, and apply the log settings
+func (c *Config) check() error {
+	if c.Listen == "" && !c.printConfig {
+		return errors.New("no address to listen on given")
+	}
+	if _, err := c.Hasher(); err != nil {
+		return err
+	}
+	if err := c.checkTLS(); err != nil {
+		return err
+	}
+	if c.fs.Lookup("maxhashing") != nil &&
+		(c.RateLimit < 0 || c.Burst < 1 || c.MaxHashing < 1) {
+		return errors.New("need a -ratelimit of 0 or more, and a -burst and -maxhashing of at least 1")
+	}
+	if c.fs.Lookup("keyscheme") != nil {
+		if _, err := hashstore.NewKey(c.KeyScheme); err != nil {
+			return err
+		}
+	}
+	if c.fs.Lookup("auditlog") != nil && (c.AuditMax < 0 || c.AuditKeep < 1) {
+		return errors.New("need an -auditmaxsize of 0 or more and an -auditkeep of at least 1")
+	}
+	if c.fs.Lookup("workers") != nil && (c.Workers < 1 || c.Queue < 0) {
+		return errors.New("need at least one worker and a queue of 0 or more")
+	}
+	if c.fs.Lookup("maxparams") != nil {
+		if _, err := c.Policy(); err != nil {
+			return err
+		}
+	}
+	return c.checkLogging()
+}
+
+// Hasher -- the password hasher of the Algorithm and Params settings
+func (c *Config) Hasher() (passhash.Hasher, error) {
+	return passhash.NewHasherParams(c.Algorithm, c.Params)
+}
+
+// Policy -- the hashing policy of the Allow and MaxParams settings, on top
+// of passhash.DefaultPolicy of the server's hasher
+func (c *Config) Policy() (passhash.Policy, error) {
+	h, err := c.Hasher()
+	if err != nil {
+		return passhash.Policy{}, err
+	}
+	p := passhash.DefaultPolicy(h)
+	if c.Allow != "" {
+		p.Allowed = nil
+		for _, name := range strings.Split(c.Allow, ",") {
+			h, err := passhash.NewHasher(strings.TrimSpace(name))
+			if err != nil {
+				return passhash.Policy{}, fmt.Errorf("-allow: %v", err)
+			}
+			p.Allowed = append(p.Allowed, h.Name())
+		}
+	}
+	for param, v := range c.MaxParams {
+		name, prm, _ := strings.Cut(param, ".")
+		if _, ok := p.Max[name][prm]; !ok {
+			return passhash.Policy{}, fmt.Errorf(
+				"-maxparams: no parameter %q, expecting algorithm.name", param)
+		}
+		p.Max[name][prm] = v
+	}
+	return p, nil
+}
+
+// PrintConfig -- whether -print-config was given
+func (c *Config) PrintConfig() bool {
+	return c.printConfig
+}
+
+// Write -- write the settings to w as a JSON config file
+func (c *Config) Write(w io.Writer) error {
+	settings := map[string]interface{}{}
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if !settingFlag(f.Name) {
+			return
+		}
+		switch v := f.Value.(flag.Getter).Get().(type) {
+		case time.Duration:
+			settings[f.Name] = v.String()
+		default:
+			settings[f.Name] = v
+		}
+	})
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Params -- algorithm cost parameters, a flag.Value spelt name=value,...
+type Params map[string]int
+
+func (p Params) String() string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + "=" + strconv.Itoa(p[name])
+	}
+	return strings.Join(names, ",")
+}
+
+// Set -- replace the parameters by those of s
+func (p Params) Set(s string) error {
+	for name := range p {
+		delete(p, name)
+	}
+	if s == "" {
+		return nil
+	}
+	for _, param := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(param, "=")
+		n, err := strconv.Atoi(value)
+		if !ok || name == "" || err != nil {
+			return fmt.Errorf("bad parameter %q, expecting name=integer", param)
+		}
+		p[name] = n
+	}
+	return nil
+}
+
+func (p Params) Get() interface{} {
+	return map[string]int(p)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2018 Steven B. Wahl.  All rights reserved.
+//
+// Use of this source code is governed by a BSD-style licence
+// that can be found in the LICENSE file or at:
+// http://steeltemple.com/steve/LICENSE
+//
+// TLS, so passwords do not cross the network in the clear.  Given
+// -tlscert and -tlskey a server only speaks https; given -tlsclientca as
+// well, clients must present a certificate signed by one of those CAs
+// (mutual TLS), and handlers learn who they are from ClientIdentity.
+// The files are read again on SIGHUP, and when they change:
+//
+//    $ ./httpHashPWsvr_no6 -listen :8443 -tlscert server.pem -tlskey server.key \
+//        -tlsclientca clients-ca.pem
+//    $ curl --cacert ca.pem --cert client.pem --key client.key \
+//        --data password=angryMonkey https://localhost:8443/hash
+//
+
+package svrconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloader -- the server certificate and client CAs, read again from
+// their files on SIGHUP or once they change
+type certReloader struct {
+	certFile, keyFile, caFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool // nil unless mutual TLS
+	modTimes  []time.Time    // of the files when last read
+}
+
+// files -- the files the certificates are read from
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+// modified -- modification times of the files, nil if any is unreadable
+func (r *certReloader) modified() []time.Time {
+	var times []time.Time
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil
+		}
+		times = append(times, fi.ModTime())
+	}
+	return times
+}
+
+// load -- read the files.  On error the certificates in use are kept.
+func (r *certReloader) load() error {
+	modTimes := r.modified()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no PEM certificates found", r.caFile)
+		}
+	}
+	r.mu.Lock()
+	r.cert, r.clientCAs, r.modTimes = &cert, pool, modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// changed -- whether the files have been modified since last read
+func (r *certReloader) changed() bool {
+	now := r.modified()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if now == nil || len(now) != len(r.modTimes) {
+		return false
+	}
+	for i := range now {
+		if !now[i].Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch -- reload on SIGHUP, and every interval if the files changed
+func (r *certReloader) watch(interval time.Duration) {
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+	for {
+		select {
+		case <-hups:
+			slog.Info("SIGHUP, reloading TLS certificates")
+		case <-tick:
+			if !r.changed() {
+				continue
+			}
+			slog.Info("TLS certificate files changed, reloading")
+		}
+		if err := r.load(); err != nil {
+			slog.Error("reloading TLS certificates, keeping the old", "err", err)
+		}
+	}
+}
+
+// configForClient -- tls.Config of a connection, with the certificates
+// currently loaded
+func (r *certReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	conf := &tls.Config{MinVersion: tls.VersionTLS12,
+		Certificates: []tls.Certificate{*r.cert}}
+	if r.clientCAs != nil {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		conf.ClientCAs = r.clientCAs
+	}
+	return conf, nil
+}
+
+// checkTLS -- validate the TLS settings
+func (c *Config) checkTLS() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("-tlscert and -tlskey must be given together")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		return errors.New("-tlsclientca needs -tlscert and -tlskey")
+	}
+	return nil
+}
+
+// Listener -- listen on the Listen address, speaking TLS if configured
+func (c *Config) Listener() (net.Listener, error) {
+	if c.TLSCert == "" {
+		return Listen(c.Listen)
+	}
+	r := &certReloader{certFile: c.TLSCert, keyFile: c.TLSKey,
+		caFile: c.TLSClientCA}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	ln, err := Listen(c.Listen)
+	if err != nil {
+		return nil, err
+	}
+	go r.watch(c.TLSReload)
+	return tls.NewListener(ln, &tls.Config{MinVersion: tls.VersionTLS12,
+		GetConfigForClient: r.configForClient}), nil
+}
+
+// ClientIdentity -- who the verified client certificate of req names: its
+// common name, else its first DNS name or email address.  "" without
+// mutual TLS.
+func ClientIdentity(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}